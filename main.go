@@ -1,10 +1,18 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"log"
 	"os"
+	"strings"
 
+	"github.com/Ardelean-Calin/elmo/pkg/buffer"
+	"github.com/Ardelean-Calin/elmo/pkg/buffer/syntax"
+	"github.com/Ardelean-Calin/elmo/pkg/config"
+	"github.com/Ardelean-Calin/elmo/pkg/themes"
 	"github.com/Ardelean-Calin/elmo/ui/components/footer"
+	"github.com/Ardelean-Calin/elmo/ui/components/picker"
 	"github.com/Ardelean-Calin/elmo/ui/components/statusbar"
 	"github.com/Ardelean-Calin/elmo/ui/components/textarea"
 
@@ -21,14 +29,15 @@ import (
 // - Helix keybindings
 // - no themes - actually, some themes maybe :D
 
-// Editor mode
+// Editor mode. The buffer decides which mode its keys switch to, as only it
+// knows whether a key is a command or part of a sequence like "mi".
 type Mode int
 
 const (
-	Normal Mode = iota
-	Insert
-	Select
-	Command
+	Normal  = Mode(buffer.Normal)
+	Insert  = Mode(buffer.Insert)
+	Select  = Mode(buffer.Select)
+	Command = Mode(buffer.Command)
 )
 
 // Supported messages
@@ -50,16 +59,73 @@ type Model struct {
 	textarea  textarea.Model
 	statusbar statusbar.Model
 	footer    footer.Model // Command bar + error and status messages
+	picker    picker.Model // Shown over the bottom of the textarea
 	// Internal data
 	currentMode Mode // Current editor mode
+	config      config.Config
+	theme       *themes.Theme
+	palette     map[string]string // Palette to restore if the theme picker is canceled
+	loadErr     error             // Shown once started, like broken macros in the config
 }
 
-func initialModel() Model {
-	return Model{
-		textarea:    textarea.New(),
+func initialModel(cfg config.Config, langs *syntax.Registry, theme *themes.Theme) Model {
+	m := Model{
+		textarea:    textarea.New(cfg, langs, theme),
 		statusbar:   statusbar.New(),
-		footer:      footer.New(),
+		footer:      footer.New(theme),
+		picker:      picker.New(theme),
 		currentMode: Normal,
+		config:      cfg,
+		theme:       theme,
+	}
+	m.footer.SetCompleter(complete)
+	return m
+}
+
+// commandNames lists the commands completed in command mode
+var commandNames = []string{"open", "quit", "buffer-close", "write", "theme"}
+
+// complete returns the completions for the last word of a command
+func complete(words []string) []string {
+	var candidates []string
+	switch {
+	case len(words) == 1:
+		candidates = commandNames
+	case len(words) == 2 && words[0] == "theme":
+		for _, t := range themes.Base16Themes() {
+			candidates = append(candidates, t.Name)
+		}
+	}
+
+	var completions []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, words[len(words)-1]) {
+			completions = append(completions, c)
+		}
+	}
+	return completions
+}
+
+// previewTheme applies the colors of the named base16 theme
+func (m *Model) previewTheme(name string) bool {
+	theme, ok := themes.FindBase16(name)
+	if ok {
+		m.theme.SetPalette(theme.Palette())
+	}
+	return ok
+}
+
+// setTheme applies the named base16 theme and saves it in the user config
+func (m *Model) setTheme(name string) tea.Cmd {
+	if !m.previewTheme(name) {
+		return footer.ShowError(fmt.Errorf("Unknown theme: '%s'", name))
+	}
+	m.config.Editor.Theme = name
+	return func() tea.Msg {
+		if err := config.Set("editor", "theme", name); err != nil {
+			return footer.ErrorMsg(fmt.Sprintf("Theme not saved: %s", err))
+		}
+		return footer.StatusMsg(fmt.Sprintf("Theme set to '%s'", name))
 	}
 }
 
@@ -70,11 +136,14 @@ func OpenBufferCmd(path string) tea.Cmd {
 }
 
 func (m Model) Init() tea.Cmd {
-	if len(os.Args) > 1 {
-		return OpenBufferCmd(os.Args[1])
+	var cmds []tea.Cmd
+	if m.loadErr != nil {
+		cmds = append(cmds, footer.ShowError(m.loadErr))
+	}
+	if flag.NArg() > 0 {
+		cmds = append(cmds, OpenBufferCmd(flag.Arg(0)))
 	}
-	// Just return `nil`, which means "no I/O right now, please."
-	return nil
+	return tea.Batch(cmds...)
 }
 
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -85,40 +154,20 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	// Window was resized
 	case tea.WindowSizeMsg:
 		m.statusbar.Width = msg.Width
+		m.picker.Width = msg.Width
 
+	// The picker takes all the keys while it's opened
 	case tea.KeyMsg:
+		if m.picker.Opened() {
+			m.picker, cmd = m.picker.Update(msg)
+			return m, cmd
+		}
+
 		m.footer.Clear()
 
-		key := msg.String()
-		if m.currentMode == Command { // Handle command mode
-			// Exit command mode
-			if key == "esc" {
-				cmd = SwitchMode(Normal)
-			}
-		} else if m.currentMode == Insert { // Handle insert mode
-			// Exit insert mode
-			if key == "esc" {
-				cmd = SwitchMode(Normal)
-			}
-		} else if m.currentMode == Select { // Handle select mode
-			// Exit select mode
-			if key == "esc" {
-				cmd = SwitchMode(Normal)
-			}
-		} else if m.currentMode == Normal { // Handle normal mode
-			if key == ":" {
-				cmd = SwitchMode(Command)
-			} else if key == "i" {
-				cmd = SwitchMode(Insert)
-			} else if key == "v" {
-				cmd = SwitchMode(Select)
-			} else {
-				// m.viewport, cmd = m.viewport.Update(msg)
-			}
-		}
-		// TODO. Just for now so I can quit. Remove
-		if key == "ctrl+c" {
-			return m, tea.Quit
+		// Exit command mode
+		if m.currentMode == Command && msg.String() == "esc" {
+			cmd = SwitchMode(Normal)
 		}
 
 	// An action such as open, write, etc.
@@ -143,6 +192,19 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			cmd = CloseBuffers(arguments...)
 		case "w", "write":
 			cmd = m.textarea.Buffer.WriteToDisk()
+		case "theme":
+			if len(arguments) > 1 {
+				cmd = footer.ShowError(fmt.Errorf("'theme' takes a single theme name."))
+			} else if arguments != nil {
+				cmd = m.setTheme(arguments[0])
+			} else {
+				var names []string
+				for _, t := range themes.Base16Themes() {
+					names = append(names, t.Name)
+				}
+				m.palette = m.theme.Palette()
+				m.picker.Open("theme", names, m.config.Editor.Theme)
+			}
 		default:
 			cmd = footer.ShowError(fmt.Errorf("Unrecognized command: '%s'", command))
 		}
@@ -152,9 +214,20 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case footer.CancelMsg:
 		cmd = SwitchMode(Normal)
 
+	// Themes are previewed while moving through the picker
+	case picker.ChangedMsg:
+		m.previewTheme(string(msg))
+
+	case picker.SelectedMsg:
+		cmd = m.setTheme(string(msg))
+
+	case picker.CanceledMsg:
+		m.theme.SetPalette(m.palette)
+
 	// Switched to a new buffer
 	case textarea.BufSwitchedMsg:
 		m.statusbar.SetOpenBuffer(m.textarea.CurBufPath())
+		m.statusbar.SetLargeFile(m.textarea.Buffer.LargeFile())
 
 	// An "open a new buffer" message was received
 	case OpenBufferMsg:
@@ -163,47 +236,73 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	// A mode switch was selected.
 	case ModeSwitchMsg:
-		m.currentMode = Mode(msg)
-
-		// Depending on mode, we can do stuff, like a hook on mode change
-		switch Mode(msg) {
-		case Insert:
-			// Do stuff, for example, enable absolute line mode in the editor
-			m.textarea.Focused = true
-			m.statusbar.InsertMode()
-			m.footer.Blur()
-		case Normal:
-			m.footer.Blur()
-			m.statusbar.NormalMode()
-			m.textarea.Focused = false
-		case Select:
-			m.statusbar.SelectMode()
-			m.footer.Blur()
-		case Command:
-			m.footer.Focus()
-		}
+		m.textarea.Buffer.Mode = buffer.Mode(msg)
+		m.setMode(Mode(msg))
 
 	}
 	cmds = append(cmds, cmd)
 
-	// Send all events to each of the components. If they are focused they might react.
-	m.textarea, cmd = m.textarea.Update(msg)
-	cmds = append(cmds, cmd)
+	// Send all events to each of the components. If they are focused they
+	// might react. Keys typed on the command line are only for the footer.
+	if _, ok := msg.(tea.KeyMsg); !ok || m.currentMode != Command {
+		m.textarea, cmd = m.textarea.Update(msg)
+		cmds = append(cmds, cmd)
+	}
 	m.footer, cmd = m.footer.Update(msg)
 	cmds = append(cmds, cmd)
+	// Follow the mode the keys switched the buffer to. The footer only gets
+	// focused afterwards, so the ":" opening it isn't part of the command.
+	if mode := Mode(m.textarea.Buffer.Mode); mode != m.currentMode {
+		m.setMode(mode)
+	}
 
 	return m, tea.Batch(cmds...)
 }
 
+// setMode switches to another mode
+func (m *Model) setMode(mode Mode) {
+	m.currentMode = mode
+
+	// Depending on mode, we can do stuff, like a hook on mode change
+	switch mode {
+	case Insert:
+		// Do stuff, for example, enable absolute line mode in the editor
+		m.textarea.Focused = true
+		m.statusbar.InsertMode()
+		m.footer.Blur()
+	case Normal:
+		m.footer.Blur()
+		m.statusbar.NormalMode()
+		m.textarea.Focused = false
+	case Select:
+		m.statusbar.SelectMode()
+		m.footer.Blur()
+	case Command:
+		m.footer.Focus()
+	}
+}
+
 func (m Model) View() string {
 	return lipgloss.JoinVertical(
 		lipgloss.Top,
-		m.textarea.View(),
+		m.overlay(m.textarea.View(), m.picker.View()),
 		m.statusbar.View(),
 		m.footer.View())
 	// TODO: I can enhance the experience with pop-ups which render **over** the text I got above.
 }
 
+// overlay replaces the last lines of view with the lines of popup
+func (m Model) overlay(view, popup string) string {
+	if popup == "" {
+		return view
+	}
+	lines := strings.Split(view, "\n")
+	popupLines := strings.Split(popup, "\n")
+	start := max(0, len(lines)-len(popupLines))
+	lines = append(lines[:start], popupLines...)
+	return strings.Join(lines, "\n")
+}
+
 // Tries to close all the buffers received. Called when running "bc", for example
 func CloseBuffers(buffers ...string) tea.Cmd {
 	var msgs tea.BatchMsg
@@ -218,6 +317,22 @@ type ActionInterface interface {
 }
 
 func main() {
+	color := flag.String("color", themes.ColorAuto, "when to use colors: never, auto or always")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [--color=never|auto|always] [file]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// Colors get converted to the closest ones the terminal supports
+	profile, err := themes.DetectProfile(*color, os.Stdout, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(2)
+	}
+	lipgloss.SetColorProfile(profile)
+
 	var debugFile string
 	if len(os.Getenv("DEBUG")) > 0 {
 		debugFile = "debug.log"
@@ -232,9 +347,33 @@ func main() {
 	}
 	defer f.Close()
 
+	// A broken config shouldn't prevent editing, fall back to the defaults
+	cfg, err := config.Load()
+	if err != nil {
+		log.Printf("Error loading config: %v", err)
+	}
+	langs, err := syntax.Load()
+	if err != nil {
+		log.Printf("Error loading languages: %v", err)
+	}
+	theme, err := themes.Load()
+	if err != nil {
+		log.Printf("Error loading theme: %v", err)
+	}
+	if cfg.Editor.Theme != "" {
+		if base16, ok := themes.FindBase16(cfg.Editor.Theme); ok {
+			theme.SetPalette(base16.Palette())
+		} else {
+			log.Printf("Unknown theme: %s", cfg.Editor.Theme)
+		}
+	}
+
+	model := initialModel(cfg, langs, theme)
+	model.loadErr = buffer.CheckMacros(cfg.Macros)
+
 	// Start Bubbletea
 	p := tea.NewProgram(
-		initialModel(),
+		model,
 		tea.WithAltScreen(),
 		tea.WithMouseCellMotion(), // turn on mouse support so we can track the mouse wheel
 	)
@@ -0,0 +1,168 @@
+package buffer
+
+import (
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// Matching brackets. The syntax tree tells which brackets belong together,
+// so brackets inside strings and comments get skipped. Without a tree, the
+// text gets scanned instead.
+
+// openingBrackets are the brackets which open a pair, with the one closing it
+var openingBrackets = map[byte]byte{'{': '}', '(': ')', '[': ']'}
+
+// maxBracketScan limits how far the text gets scanned for a bracket, so that
+// large files stay responsive
+const maxBracketScan = 16 * 1024
+
+// isBracket reports whether c is an opening or closing bracket
+func isBracket(c byte) bool {
+	return openingBrackets[c] != 0 || closingBrackets[c] != 0
+}
+
+// matchingBracket returns the position of the bracket matching the one at
+// pos, or -1 if there is none. root may be nil.
+func (s *SourceCode) matchingBracket(root *sitter.Node, pos int) int {
+	if pos < 0 || pos >= s.data.Len() || !isBracket(s.data.ByteAt(pos)) {
+		return -1
+	}
+	if root == nil {
+		return s.scanBracket(pos, 0, s.data.Len())
+	}
+	n := descendantAt(root, pos)
+	if n.ChildCount() == 0 && n.Type() == string(s.data.ByteAt(pos)) {
+		return siblingBracket(n)
+	}
+	// A bracket inside a string or comment only matches inside it
+	return s.scanBracket(pos, int(n.StartByte()), int(n.EndByte()))
+}
+
+// siblingBracket returns the position of the bracket matching the bracket
+// token n, among its siblings. Returns -1 if there is none.
+func siblingBracket(n *sitter.Node) int {
+	c := n.Type()[0]
+	match, forward := openingBrackets[c], true
+	if match == 0 {
+		match, forward = closingBrackets[c], false
+	}
+
+	depth := 0
+	next := n.NextSibling
+	if !forward {
+		next = n.PrevSibling
+	}
+	for sibling := next(); sibling != nil; {
+		switch sibling.Type() {
+		case string(c):
+			depth++
+		case string(match):
+			if depth == 0 {
+				if sibling.IsMissing() {
+					return -1
+				}
+				return int(sibling.StartByte())
+			}
+			depth--
+		}
+		if forward {
+			sibling = sibling.NextSibling()
+		} else {
+			sibling = sibling.PrevSibling()
+		}
+	}
+	return -1
+}
+
+// scanBracket returns the position of the bracket matching the one at pos,
+// looking only at the text in [start, end). Returns -1 if there is none.
+func (s *SourceCode) scanBracket(pos, start, end int) int {
+	c := s.data.ByteAt(pos)
+	if match, ok := openingBrackets[c]; ok {
+		end = min(end, pos+maxBracketScan)
+		depth := 0
+		for i := pos + 1; i < end; i++ {
+			switch s.data.ByteAt(i) {
+			case c:
+				depth++
+			case match:
+				if depth == 0 {
+					return i
+				}
+				depth--
+			}
+		}
+		return -1
+	}
+	return s.findOpening(pos, closingBrackets[c], c, max(start, pos-maxBracketScan))
+}
+
+// enclosingBrackets returns the positions of the innermost pair of brackets
+// around pos, or -1 and -1 if there is none. root may be nil.
+func (s *SourceCode) enclosingBrackets(root *sitter.Node, pos int) (int, int) {
+	if root == nil {
+		// Any closing bracket on the way closes a pair which isn't around pos
+		depth := 0
+		for i := pos - 1; i >= max(0, pos-maxBracketScan); i-- {
+			c := s.data.ByteAt(i)
+			switch {
+			case closingBrackets[c] != 0:
+				depth++
+			case openingBrackets[c] != 0 && depth > 0:
+				depth--
+			case openingBrackets[c] != 0:
+				if close := s.scanBracket(i, 0, s.data.Len()); close >= pos {
+					return i, close
+				}
+				return -1, -1
+			}
+		}
+		return -1, -1
+	}
+
+	for n := descendantAt(root, pos); n != nil; n = n.Parent() {
+		for i := 0; i < int(n.ChildCount()); i++ {
+			child := n.Child(i)
+			start := int(child.StartByte())
+			if start >= pos {
+				break
+			}
+			if child.ChildCount() > 0 || openingBrackets[s.data.ByteAt(start)] == 0 || child.Type() != string(s.data.ByteAt(start)) {
+				continue
+			}
+			if close := siblingBracket(child); close >= pos {
+				return start, close
+			}
+		}
+	}
+	return -1, -1
+}
+
+// bracketPair returns the brackets highlighted along with the cursor: the
+// one under the cursor and its match, or else the pair around the cursor.
+// Positions are -1 if there is nothing to highlight.
+func (s *SourceCode) bracketPair() [2]int {
+	root := s.syntaxTree()
+	if match := s.matchingBracket(root, s.cursor); match >= 0 {
+		return [2]int{s.cursor, match}
+	}
+	if s.cursor < s.data.Len() && isBracket(s.data.ByteAt(s.cursor)) {
+		// An unmatched bracket doesn't show the pair around it
+		return [2]int{-1, -1}
+	}
+	open, close := s.enclosingBrackets(root, s.cursor)
+	return [2]int{open, close}
+}
+
+// MatchBracket moves the cursor to the bracket matching the one under it.
+// Inside a pair of brackets, the cursor goes to the closing one.
+func (s *SourceCode) MatchBracket() {
+	root := s.freshTree()
+	match := s.matchingBracket(root, s.cursor)
+	if match < 0 {
+		_, match = s.enclosingBrackets(root, s.cursor)
+	}
+	if match >= 0 {
+		s.SetCursor(match)
+		s.RelalcHpos()
+	}
+}
@@ -3,72 +3,102 @@ package buffer
 import (
 	"bytes"
 	"context"
-	_ "embed"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 
+	"github.com/Ardelean-Calin/elmo/pkg/buffer/syntax"
+	"github.com/Ardelean-Calin/elmo/pkg/config"
 	"github.com/Ardelean-Calin/elmo/pkg/gapbuffer"
+	"github.com/Ardelean-Calin/elmo/pkg/lineindex"
+	"github.com/Ardelean-Calin/elmo/pkg/rope"
+	"github.com/Ardelean-Calin/elmo/pkg/storage"
+	"github.com/Ardelean-Calin/elmo/pkg/themes"
 	"github.com/Ardelean-Calin/elmo/ui/components/footer"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 
 	sitter "github.com/smacker/go-tree-sitter"
-	"github.com/smacker/go-tree-sitter/golang"
-	"github.com/smacker/go-tree-sitter/nix"
-	"github.com/smacker/go-tree-sitter/rust"
 )
 
-// Catppuccin Mocha
-var theme = []string{
-	"#1e1e2e", // base
-	"#181825", // mantle
-	"#313244", // surface0
-	"#45475a", // surface1
-	"#585b70", // surface2
-	"#cdd6f4", // text
-	"#f5e0dc", // rosewater
-	"#b4befe", // lavender
-	"#f38ba8", // red
-	"#fab387", // peach
-	"#f9e2af", // yellow
-	"#a6e3a1", // green
-	"#94e2d5", // teal
-	"#89b4fa", // blue
-	"#cba6f7", // mauve
-	"#f2cdcd", // flamingo
-}
-
-// Contains the new cursor coordinates
+// TreeInitMsg is sent once the language of a newly opened file is known,
+// along with its first syntax tree
 type TreeInitMsg struct {
-	tree    *sitter.Node
 	lang    *sitter.Language
-	queries *sitter.Query
+	queries languageQueries
+	parse   ParseDoneMsg
+}
+
+// ParseDoneMsg carries the result of a background parse
+type ParseDoneMsg struct {
+	source  *SourceCode // The parsed source, which might not be open anymore
+	version int         // Version of the source that was parsed
+	tree    *sitter.Tree
+	colors  []themes.Scope
 }
 
 // SourceCode is the main container for the opened files. TODO name to something more generic, like Buffer?
 type SourceCode struct {
-	// Stores the raw data bytes. To be replaced with gapbuffer
-	data gapbuffer.GapBuffer
-	// Contains a base16 color for each character
-	colors []byte
+	// Stores the raw data bytes
+	data storage.Storage
+	// Contains the highlight scope of each character. Nil until the first
+	// highlight and for files without syntax highlighting.
+	colors []themes.Scope
 	// Cursor index
 	cursor int
 	// Horizontal position within line
 	hpos int
 	// Currently selected text range
 	selectAnchor, selectEnd int
-	// Treesitter representation
-	tree    *sitter.Node
+	// Selections made before the structural ones, see ExpandSelection
+	selectionStack []selection
+	// Selection made by the last structural command. Once the selection
+	// changes any other way, the stack gets dropped.
+	structural selection
+	// Treesitter representation. Edits are applied to it too, so the next
+	// parse only has to redo the parts they touched.
+	tree    *sitter.Tree
 	lang    *sitter.Language
-	queries *sitter.Query
+	queries languageQueries
+	// One level of indentation, like "\t" or "    "
+	indent string
+	// Characters closing the auto-pairs, by opening character. Nil for the
+	// default ones.
+	pairs map[byte]byte
+	// Comment tokens of the language
+	comment      string
+	blockComment syntax.BlockComment
+	// Known languages, for the ones injected into this one
+	langs *syntax.Registry
 	// Info about every single line
-	lines map[int]Line
+	lines *lineindex.Index
+	// Large file mode: the content is memory mapped and there is no syntax
+	// highlighting
+	large bool
+	// Incremented on every edit, used to drop outdated parse results
+	version int
+	// Version the tree was parsed from
+	parsed int
+	// Incremented whenever a parse replaces the colors
+	highlighted int
+	// Cancels the background parse that is currently running
+	cancelParse context.CancelFunc
+	// Lines rendered by the last View, by line number
+	rendered map[int]renderedLine
+	// Closed folds, from the start of their first line until the end of
+	// their last one
+	folds []textRange
+	// Edits which can be undone
+	edits history
 }
 
 func (s *SourceCode) SetCursor(pos int) {
@@ -84,7 +114,7 @@ func (s *SourceCode) RelalcHpos() {
 	// Step 2: Inside this line, calculate a horizontal position
 	hpos := 0
 	for i := line.start; i < s.cursor; i++ {
-		if s.data.GetAbs(i) == '\t' {
+		if s.data.ByteAt(i) == '\t' {
 			hpos += 4
 		} else {
 			hpos += 1
@@ -112,13 +142,37 @@ func (s *SourceCode) GetSelection() (start, end int) {
 // CurrentLine returns the current line index and value
 func (s *SourceCode) CurrentLine() (int, Line, error) {
 	c := s.cursor
-	for i, v := range s.lines {
-		if v.start <= c && c <= v.end {
-			return i, v, nil
-		}
+	if c < 0 || c > s.data.Len() {
+		return -1, Line{}, fmt.Errorf("Could not find index %d", c)
 	}
 
-	return -1, Line{}, fmt.Errorf("Could not find index %d", c)
+	i, _ := s.lines.Position(c)
+	return i, s.Line(i), nil
+}
+
+// Line returns the line with the given index
+func (s *SourceCode) Line(i int) Line {
+	start, end := s.lines.Line(i)
+	return Line{start, end}
+}
+
+// LineCount returns the number of lines. Large files are only read as far
+// as needed, so prefer hasLine when the end of the file doesn't matter.
+func (s *SourceCode) LineCount() int {
+	return s.lines.Len()
+}
+
+// hasLine reports whether the line with the given index exists
+func (s *SourceCode) hasLine(i int) bool {
+	return s.lines.HasLine(i)
+}
+
+// clampLine limits i to the existing lines
+func (s *SourceCode) clampLine(i int) int {
+	if i <= 0 || s.hasLine(i) {
+		return max(i, 0)
+	}
+	return s.LineCount() - 1
 }
 
 // Line describes a line. Using this I can easily index lines and get their length and indentation
@@ -131,7 +185,7 @@ type Line struct {
 func (s *SourceCode) LineWidth(l Line) int {
 	width := 0
 	for i := l.start; i < l.end; i++ {
-		if s.data.GetAbs(i) == '\t' {
+		if s.data.ByteAt(i) == '\t' {
 			// TODO: Replace 4 with configurable value
 			width += 4
 		} else {
@@ -142,130 +196,284 @@ func (s *SourceCode) LineWidth(l Line) int {
 	return width
 }
 
-// SetSource loads a file and computes the appropriate LineInfo's
-func (s *SourceCode) SetSource(source []byte) {
-	buf := gapbuffer.NewGapBuffer()
-	buf.SetContent(source)
-
-	s.data = buf
-	s.colors = bytes.Repeat([]byte{0x05}, len(source))
+// SetSource loads a file and computes the appropriate LineInfo's. Large
+// files are stored in a rope, which never writes to source, so it can be
+// read-only memory.
+func (s *SourceCode) SetSource(source []byte, large bool) {
+	if large {
+		s.data = rope.New(source)
+	} else {
+		buf := gapbuffer.NewGapBuffer()
+		buf.SetContent(source)
+		s.data = &buf
+	}
+	s.large = large
+	s.colors = nil
 	s.cursor = 0
 	s.hpos = 0
 	s.tree = nil
-	s.RegenerateLines()
+	s.folds = nil
+	s.rendered = nil
+	s.edits = history{}
+	if large {
+		// Reading all of a huge file for its lines would hold up opening it
+		s.lines = lineindex.NewLazy(source)
+	} else {
+		s.lines = lineindex.New(source)
+	}
+}
+
+// Reparse parses the current content in the background. A parse that is
+// still running gets cancelled, as its result would be outdated anyway.
+// The result is delivered as a ParseDoneMsg. Does nothing for files without
+// syntax highlighting.
+func (s *SourceCode) Reparse() tea.Cmd {
+	if s.lang == nil || s.large {
+		return nil
+	}
+	if s.cancelParse != nil {
+		s.cancelParse()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancelParse = cancel
+
+	snapshot := s.data.Snapshot()
+	version, lang, queries, langs := s.version, s.lang, s.queries, s.langs
+	// The parse gets its own copy, as the tree keeps being edited here
+	var old *sitter.Tree
+	if s.tree != nil {
+		old = s.tree.Copy()
+	}
+	return func() tea.Msg {
+		tree, colors, ok := parse(ctx, snapshot, lang, old, queries, langs)
+		if !ok {
+			return nil
+		}
+		return ParseDoneMsg{source: s, version: version, tree: tree, colors: colors}
+	}
 }
 
-func (s *SourceCode) GenerateTree() *sitter.Node {
-	newTree, _ := sitter.ParseCtx(context.Background(), s.data.Bytes(), s.lang)
+// applyParse stores the result of a parse, unless the source has been edited
+// since. Returns false in that case.
+func (s *SourceCode) applyParse(msg ParseDoneMsg) bool {
+	if msg.source != s || msg.version != s.version {
+		return false
+	}
+	s.tree = msg.tree
+	s.colors = msg.colors
+	s.parsed = msg.version
+	s.highlighted++
+	return true
+}
 
-	return newTree
+// parse parses text with tree-sitter and generates its colors. Returns false
+// if ctx got cancelled in the meantime.
+func parse(ctx context.Context, text storage.Text, lang *sitter.Language, old *sitter.Tree, queries languageQueries, langs *syntax.Registry) (*sitter.Tree, []themes.Scope, bool) {
+	tree, ok := parseTree(ctx, text, lang, old)
+	if !ok {
+		return nil, nil, false
+	}
+	colors := generateColors(ctx, text, tree.RootNode(), queries, langs)
+	if ctx.Err() != nil {
+		return nil, nil, false
+	}
+	return tree, colors, true
 }
 
-// GenerateColors generates the new Syntax Highlighting for the current
-// tree. It is a blocking operation that should take as little as possible.
-func (s *SourceCode) GenerateColors() []byte {
-	srcBytes, err := io.ReadAll(s.data.Reader())
-	if err != nil {
-		log.Panic(err)
+// parseTree parses text with tree-sitter, without highlighting it. The
+// parser reads the text in chunks, straight out of the storage. If old isn't
+// nil, it is the edited tree of a previous version of text, and only what
+// changed since gets parsed again.
+func parseTree(ctx context.Context, text storage.Text, lang *sitter.Language, old *sitter.Tree) (*sitter.Tree, bool) {
+	parser := sitter.NewParser()
+	parser.SetLanguage(lang)
+	tree, err := parser.ParseInputCtx(ctx, old, textInput(ctx, text))
+	if err != nil || ctx.Err() != nil {
+		return nil, false
+	}
+	return tree, true
+}
+
+// textInput lets tree-sitter read text in chunks, straight out of the
+// storage
+func textInput(ctx context.Context, text storage.Text) sitter.Input {
+	// tree-sitter copies every chunk, so we can reuse the buffer
+	buf := make([]byte, 16<<10)
+	return sitter.Input{
+		Read: func(offset uint32, _ sitter.Point) []byte {
+			// Pretending we reached the end makes a cancelled parse
+			// finish early
+			if ctx.Err() != nil {
+				return nil
+			}
+			n, _ := text.ReadAt(buf, int64(offset))
+			return buf[:n]
+		},
+		Encoding: sitter.InputEncodingUTF8,
+	}
+}
+
+// generateColors generates the Syntax Highlighting for the given tree and
+// the languages injected into it. Stops early if ctx gets cancelled.
+func generateColors(ctx context.Context, text storage.Text, tree *sitter.Node, queries languageQueries, langs *syntax.Registry) []themes.Scope {
+	colors := make([]themes.Scope, text.Len())
+	highlight(ctx, colors, text, tree, queries.highlights, nil)
+	inject(ctx, colors, text, tree, queries, langs, 0)
+	return colors
+}
+
+// highlight sets the colors of the characters captured by the highlights
+// query. If ranges isn't nil, only the characters inside them are set.
+func highlight(ctx context.Context, colors []themes.Scope, text storage.Text, tree *sitter.Node, queries *sitter.Query, ranges []sitter.Range) {
+	// The theme decides what every capture looks like
+	scopes := make([]themes.Scope, queries.CaptureCount())
+	for i := range scopes {
+		scopes[i] = themes.ScopeOf(queries.CaptureNameForId(uint32(i)))
+	}
+	fill := func(start, end uint32, scope themes.Scope) {
+		for index := start; index < end; index++ {
+			colors[index] = scope
+		}
 	}
-	colors := bytes.Repeat([]byte{0x05}, len(srcBytes))
 
 	qc := sitter.NewQueryCursor()
-	qc.Exec(s.queries, s.tree)
+	defer qc.Close()
+	qc.Exec(queries, tree)
 
 	// Iterate over query results
-	for {
+	for ctx.Err() == nil {
 		m, ok := qc.NextMatch()
 		if !ok {
 			break
 		}
-		// Apply predicates filtering
-		m = qc.FilterPredicates(m, srcBytes)
+		if !matchesPredicates(queries, m, text) {
+			continue
+		}
 		for _, c := range m.Captures {
-			name := s.queries.CaptureNameForId(c.Index)
-
-			// The most basic of syntax highlighting!
-			// TODO. Load these associations from a file
-			var color uint8
-			switch name {
-			case "attribute":
-				color = 0x0E
-			case "comment":
-				color = 0x04
-			case "constant.builtin":
-				color = 0x09
-			case "escape":
-				color = 0x0C
-			case "function", "function.builtin", "function.method", "function.macro":
-				color = 0x0D
-			case "keyword":
-				color = 0x0E
-			case "label":
-				color = 0x0C
-			case "number":
-				color = 0x09
-			case "operator":
-				color = 0x0C
-			case "package":
-				color = 0x0D
-			case "property":
-				color = 0x0D
-			case "punctuation.bracket":
-				color = 0x05
-			case "string", "string.special.path", "string.special.uri":
-				color = 0x0B
-			case "type", "type.builtin":
-				color = 0x0A
-			case "variable.member":
-				color = 0x0C
-			case "variable.parameter":
-				color = 0x08
-			default:
-				color = 0x05
+			scope := scopes[c.Index]
+			if ranges == nil {
+				fill(c.Node.StartByte(), c.Node.EndByte(), scope)
 			}
-
-			for index := c.Node.StartByte(); index < c.Node.EndByte(); index++ {
-				colors[index] = color
+			for _, r := range ranges {
+				fill(max(c.Node.StartByte(), r.StartByte), min(c.Node.EndByte(), r.EndByte), scope)
 			}
-
 		}
 	}
+}
 
-	return colors
+// insertAt inserts text at pos. Every edit goes through insertAt and deleteAt
+func (s *SourceCode) insertAt(pos int, text []byte) {
+	s.record(change{pos: pos, inserted: slices.Clone(text)})
+	edit := s.beginEdit(pos, pos)
+	s.data.InsertAt(pos, text)
+	s.lines.Insert(pos, text)
+	s.endEdit(edit, pos+len(text))
+	s.shiftFolds(pos, pos, len(text))
+	// Shift the old colors until the new ones are ready, so the screen
+	// doesn't flicker while parsing
+	if pos <= len(s.colors) {
+		s.colors = slices.Insert(s.colors, pos, make([]themes.Scope, len(text))...)
+	}
+	s.version++
 }
 
-// RegenerateLines regenerates the line information
-func (s *SourceCode) RegenerateLines() {
-	lines := make(map[int]Line)
+// deleteAt deletes the characters in the range [start, end)
+func (s *SourceCode) deleteAt(start, end int) {
+	s.record(change{pos: start, deleted: s.data.Slice(start, end)})
+	edit := s.beginEdit(start, end)
+	s.data.DeleteAt(start, end-start)
+	s.lines.Delete(start, end-start)
+	s.endEdit(edit, start)
+	s.shiftFolds(start, end, 0)
+	if end <= len(s.colors) {
+		s.colors = slices.Delete(s.colors, start, end)
+	}
+	s.version++
+}
 
-	lineBreaks := s.data.FindAll('\n')
-	lines[0] = Line{0, lineBreaks[0]}
+// point returns the row and column of pos, as tree-sitter wants them
+func (s *SourceCode) point(pos int) sitter.Point {
+	row, col := s.lines.Position(pos)
+	return sitter.Point{Row: uint32(row), Column: uint32(col)}
+}
 
-	for i, index := range lineBreaks {
-		if i == len(lineBreaks)-1 {
-			lines[i+1] = Line{index + 1, s.data.Len()}
-			break
-		}
-		// Since the range is [open, closed) we consider a line to be starting at the first
-		// character after '\n' and ending at the last character before '\n'
-		lines[i+1] = Line{index + 1, lineBreaks[i+1]}
+// beginEdit describes to the syntax tree that the range [start, end) is
+// about to be replaced. Must be called before the content changes.
+func (s *SourceCode) beginEdit(start, end int) sitter.EditInput {
+	if s.tree == nil {
+		return sitter.EditInput{}
+	}
+	return sitter.EditInput{
+		StartIndex:  uint32(start),
+		OldEndIndex: uint32(end),
+		StartPoint:  s.point(start),
+		OldEndPoint: s.point(end),
 	}
+}
 
-	s.lines = lines
+// endEdit applies an edit started by beginEdit to the syntax tree, now that
+// the replacement ends at newEnd
+func (s *SourceCode) endEdit(edit sitter.EditInput, newEnd int) {
+	if s.tree == nil {
+		return
+	}
+	edit.NewEndIndex = uint32(newEnd)
+	edit.NewEndPoint = s.point(newEnd)
+	s.tree.Edit(edit)
+}
+
+// Insert inserts text at the cursor position and moves the cursor after it
+func (s *SourceCode) Insert(text []byte) {
+	s.insertAt(s.cursor, text)
+	s.cursor += len(text)
+}
+
+// Backspace deletes the character before the cursor. Both characters of an
+// empty auto-pair get deleted.
+func (s *SourceCode) Backspace() {
+	if s.cursor == 0 {
+		return
+	}
+	if s.emptyPair() {
+		s.deleteAt(s.cursor, s.cursor+1)
+	}
+	s.cursor--
+	s.deleteAt(s.cursor, s.cursor+1)
+}
+
+// Delete deletes the character under the cursor
+func (s *SourceCode) Delete() {
+	if s.cursor >= s.data.Len() {
+		return
+	}
+	s.deleteAt(s.cursor, s.cursor+1)
+}
+
+// DeleteRange deletes the characters in the range [start, end) and moves the
+// cursor to start
+func (s *SourceCode) DeleteRange(start, end int) {
+	end = min(end, s.data.Len())
+	s.deleteAt(start, end)
+	s.SetCursor(start)
 }
 
 // GetSlice returns the slice between start and end
 func (s *SourceCode) GetSlice(start, end int) []byte {
-	return s.data.Bytes()[start:end]
+	return s.data.Slice(start, end)
 }
 
-func (s *SourceCode) GetColors(start, end int) []byte {
-	return s.colors[start:end]
+// AppendSlice appends the bytes between start and end to dst
+func (s *SourceCode) AppendSlice(dst []byte, start, end int) []byte {
+	return s.data.AppendRange(dst, start, end)
 }
 
-// Returns a map of type lineIndex: {start in buffer, end in buffer}
-func (s *SourceCode) Lines() map[int]Line {
-	return s.lines
+// GetColors returns the highlight scopes between start and end. Text without
+// syntax highlighting is plain text.
+func (s *SourceCode) GetColors(start, end int) []themes.Scope {
+	if end > len(s.colors) {
+		return make([]themes.Scope, end-start)
+	}
+	return s.colors[start:end]
 }
 
 type Viewport struct {
@@ -280,14 +488,21 @@ const (
 	Normal Mode = iota
 	Insert
 	Select
+	Command // Typing a command, whose keys don't reach the buffer
 )
 
 // Model represents an opened file.
 type Model struct {
 	Path     string   // Absolute path on disk.
 	fd       *os.File // File descriptor.
+	config   config.Config
+	langs    *syntax.Registry
+	theme    *themes.Theme
 	Focused  bool
 	modified bool // Content was modified and not saved to disk
+	// Held while the file is being written, so that saves don't overlap.
+	// Shared by the copies of the model.
+	saving *sync.Mutex
 	// Used just once on load
 	ready bool
 	//  Then, the cursor will be strictly for display only (see footer.go)
@@ -295,14 +510,31 @@ type Model struct {
 	source   *SourceCode // This replaces everything below
 	viewport Viewport    // Scrollable viewport
 	Mode     Mode        // Current buffer mode
+	pending  string      // Keys typed so far of a sequence, like "mi"
+	// Keys of the command being typed, or of the current insert session
+	typed []tea.KeyMsg
+	// Keys of the last change, repeated by "."
+	lastChange []tea.KeyMsg
+	count      int    // Count typed before a command, like the 3 of "3q"
+	register   string // Register selected with ", like the a of "\"aQ"
+	// Macros recorded with Q, in key notation, by register. They hide the
+	// ones of the config.
+	registers map[string]string
+	recording string       // Register of the macro being recorded, if any
+	macro     []tea.KeyMsg // Keys of the macro recorded so far
+	replaying int          // How deep inside replayed keys we are
 }
 
-func New() Model {
+func New(cfg config.Config, langs *syntax.Registry, theme *themes.Theme) Model {
 	return Model{
 		Path:     "",
 		fd:       nil,
+		config:   cfg,
+		langs:    langs,
+		theme:    theme,
 		Focused:  true,
 		modified: false,
+		saving:   &sync.Mutex{},
 		ready:    false,
 		Mode:     Normal,
 		source:   nil,
@@ -316,7 +548,6 @@ func (m Model) Init() tea.Cmd {
 
 func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 	var cmds []tea.Cmd
-	var cmd tea.Cmd
 
 	switch msg := msg.(type) {
 	// On Resize, re-render the viewport
@@ -331,127 +562,48 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 		}
 
 	case tea.KeyMsg:
-		// Normal mode keybindings
-		if m.Mode == Normal {
-			// Half page up
-			if msg.String() == "ctrl+u" {
-				m.viewport.offset = clamp(m.viewport.offset-m.viewport.height/2, 0, len(m.source.lines)-m.viewport.height+2)
-			}
-			// Half page down
-			if msg.String() == "ctrl+d" {
-				m.viewport.offset = clamp(m.viewport.offset+m.viewport.height/2, 0, len(m.source.lines)-m.viewport.height+2)
-			}
-
-			if msg.String() == "j" || msg.String() == "down" {
-				m.source.cursorDown(1)
-			}
-			if msg.String() == "k" || msg.String() == "up" {
-				m.source.cursorUp(1)
-			}
-			if msg.String() == "l" || msg.String() == "right" {
-				m.source.cursorRight(1)
-			}
-			if msg.String() == "h" || msg.String() == "left" {
-				m.source.cursorLeft(1)
-			}
-
-			if msg.String() == "d" {
-				start, end := m.source.GetSelection()
-				m.source.data.CursorGoto(start)
-				m.source.data.DeleteRange(end - start + 1)
-				m.source.SetCursor(start)
-
-				// m.source.data.Delete()
-				m.source.RegenerateLines()
-				m.source.tree = m.source.GenerateTree()
-				m.source.colors = m.source.GenerateColors()
-			}
-
-			if msg.String() == "w" {
-				// Select word! No forward no backward. Just the current word
-			}
-
-			if msg.String() == "i" {
-				m.Mode = Insert
-				m.source.data.CursorGoto(m.source.cursor)
-			}
-		} else if m.Mode == Insert && msg.Alt == false {
-			if msg.String() == "esc" {
-				m.Mode = Normal
-				cmd = nil
-			}
-
-			if msg.Type == tea.KeyRunes {
-				chars := []byte(msg.String())
-				m.source.data.InsertSlice(chars)
-				m.source.cursor += len(chars)
-				m.source.RegenerateLines()
-			}
-
-			if msg.Type == tea.KeySpace {
-				m.source.data.Insert(' ')
-				m.source.cursor++
-				m.source.RegenerateLines()
-			}
-
-			if msg.Type == tea.KeyTab {
-				m.source.data.Insert('\t')
-				m.source.cursor++
-				m.source.RegenerateLines()
-			}
-
-			if msg.Type == tea.KeyEnter {
-				m.source.data.Insert('\n')
-				m.source.cursor++
-				m.source.RegenerateLines()
-			}
-
-			if msg.Type == tea.KeyBackspace {
-				m.source.data.Backspace()
-				m.source.cursor--
-				m.source.RegenerateLines()
-			}
-
-			if msg.Type == tea.KeyDelete {
-				m.source.data.Delete()
-				m.source.RegenerateLines()
-			}
-
-			if msg.Type == tea.KeyRight {
-				m.source.cursor++
-				m.source.data.CursorRight()
-			}
-
-			if msg.Type == tea.KeyLeft {
-				m.source.cursor--
-				m.source.data.CursorLeft()
+		if m.source == nil {
+			// Without a file, commands can still be typed, like :open
+			if m.Mode == Normal && msg.String() == ":" {
+				m.Mode = Command
 			}
+			break
+		}
+		// Edits are parsed in the background, see the end of this case
+		version := m.source.version
+		m.handleKey(msg)
+
+		// Every command is undone on its own, while everything typed in
+		// insert mode is undone at once
+		if m.Mode != Insert {
+			m.source.Commit()
+		}
 
-			// Blocking operations. Why? Because we don't want the screen
-			// to flicker.
-			m.source.tree = m.source.GenerateTree()
-			m.source.colors = m.source.GenerateColors()
+		// Parsing happens in the background, so typing never blocks. Until
+		// it's done, the old colors get shifted along with the edits.
+		if m.source.version != version {
+			cmds = append(cmds, m.source.Reparse())
 		}
-		cmds = append(cmds, cmd)
 
 	case tea.MouseMsg:
 		evt, action := msg.Button, msg.Action
 		switch evt {
 		// Scroll the viewport with the mouse wheel
 		case tea.MouseButtonWheelUp:
-			m.viewport.offset = clamp(m.viewport.offset-3, 0, len(m.source.lines)-m.viewport.height+2)
+			m.scroll(-3)
 		case tea.MouseButtonWheelDown:
-			m.viewport.offset = clamp(m.viewport.offset+3, 0, len(m.source.lines)-m.viewport.height+2)
+			m.scroll(3)
 		case tea.MouseButtonLeft:
 			x, y := msg.X-7, msg.Y // Allocate 7 for the line numbers + gutter
-			row := m.viewport.offset + y
-			line := m.source.lines[row]
+			// Folded lines take a single row
+			row := m.source.moveVisible(m.viewport.offset, y)
+			line := m.source.Line(row)
 
 			// Handle line indentation when rendering by mapping the
 			// x coordinate of the mouse click to a line offset iteratively
 			pos := 0
 			for i := line.start; i < line.end; i++ {
-				c := m.source.data.GetAbs(i)
+				c := m.source.data.ByteAt(i)
 				if c == '\t' {
 					x -= 4
 				} else {
@@ -466,7 +618,6 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 
 			m.source.cursor = clamp(line.start+pos, line.start, line.end+1)
 			m.source.hpos = clamp(msg.X-7, 0, m.source.LineWidth(line)+1)
-			m.source.data.CursorGoto(m.source.cursor)
 			if action == tea.MouseActionPress {
 				m.source.StartSelection()
 			}
@@ -475,11 +626,21 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 
 	// A new syntax tree has been generated. Only invoked once on file load
 	case TreeInitMsg:
-		m.source.tree = msg.tree
+		if msg.parse.source != m.source {
+			break
+		}
 		m.source.lang = msg.lang
 		m.source.queries = msg.queries
-		// Generate the syntax highlighting
-		m.source.colors = m.source.GenerateColors()
+		// The file was edited while we were parsing it
+		if !m.source.applyParse(msg.parse) {
+			cmds = append(cmds, m.source.Reparse())
+		}
+
+	case ParseDoneMsg:
+		if m.source == nil {
+			break
+		}
+		m.source.applyParse(msg)
 
 	}
 
@@ -496,64 +657,170 @@ func (m Model) View() string {
 	}
 
 	var sb strings.Builder
-	start := clamp(m.viewport.offset, 0, len(m.source.lines))
-	end := clamp(m.viewport.offset+m.viewport.height, 0, len(m.source.lines))
-	for i := start; i < end; i++ {
-		var lb strings.Builder
-		var fg, bg lipgloss.Color
-
-		lineinfo := m.source.lines[i]
-		line := m.source.GetSlice(lineinfo.start, lineinfo.end)
-		colors := m.source.GetColors(lineinfo.start, lineinfo.end)
-
-		// Write line numbers TODO I could maybe move this inside another component?
-		numberStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(theme[0x03])).Background(lipgloss.Color(theme[0x00]))
-		lb.WriteString(numberStyle.Render(fmt.Sprintf("%5d  ", i+1)))
-		// TODO: Also render the Git Gutter here using these: ▔ ▍
-
-		// Render the cursor and the selection
-		for j, b := range line {
-			absolutePos := lineinfo.start + j
-			if m.source.cursor == absolutePos {
-				lb.WriteString(lipgloss.NewStyle().Reverse(true).Render(string(b)))
-			} else {
-				fg = lipgloss.Color(theme[colors[j]])
-				// Normal render. All characters are rendered one-by-one
-				// with their appropriate color
-				start, end := m.source.GetSelection()
-				if absolutePos <= end && absolutePos >= start {
-					bg = lipgloss.Color(theme[0x02])
-				} else {
-					bg = lipgloss.Color(theme[0x00])
-				}
-
-				lb.WriteString(
-					lipgloss.NewStyle().
-						Foreground(fg).
-						Background(bg).
-						Render(string(b)))
+	var line []byte // Reused for every line, so we don't allocate per line
+	r := m.newRenderer()
+	// Only the visible lines are kept in the cache
+	rendered := make(map[int]renderedLine, m.viewport.height)
+	selStart, selEnd := m.source.GetSelection()
+	folds := m.source.closedFolds()
+	brackets := m.source.bracketPair()
+	// A closed fold takes a single row, showing its first line
+	i := folds.visible(m.source.clampLine(m.viewport.offset))
+	// The last line is visible too, even if it doesn't end with a newline
+	for row := 0; row < m.viewport.height && m.source.hasLine(i); row++ {
+		if row > 0 {
+			sb.WriteByte('\n')
+		}
+		lineinfo := m.source.Line(i)
+		length := lineinfo.end - lineinfo.start
+
+		key := lineKey{
+			number:      i,
+			version:     m.source.version,
+			highlighted: m.source.highlighted,
+			selStart:    clamp(selStart-lineinfo.start, -1, length+1),
+			selEnd:      clamp(selEnd-lineinfo.start, -1, length+1),
+			cursor:      -1,
+			brackets:    [2]int{-1, -1},
+			folded:      folds.end(i) > i,
+			theme:       m.theme.Version(),
+		}
+		if m.source.cursor >= lineinfo.start && m.source.cursor <= lineinfo.end {
+			key.cursor = m.source.cursor - lineinfo.start
+		}
+		for j, pos := range brackets {
+			if pos >= lineinfo.start && pos < lineinfo.end {
+				key.brackets[j] = pos - lineinfo.start
 			}
 		}
 
-		// If the cursor is on a line end (aka \n), render a whitespace
-		if m.source.cursor == lineinfo.end {
-			lb.WriteString(lipgloss.NewStyle().Reverse(true).Render(" "))
+		cached, ok := m.source.rendered[i]
+		if !ok || cached.key != key {
+			line = m.source.AppendSlice(line[:0], lineinfo.start, lineinfo.end)
+			colors := m.source.GetColors(lineinfo.start, lineinfo.end)
+			cached = renderedLine{key: key, text: r.render(key, line, colors)}
 		}
-
-		// Render the background
-		// bg = lipgloss.Color(theme[0x00])
-		// textLen := lipgloss.Width(lb.String())
-		// lb.WriteString(lipgloss.NewStyle().Background(bg).Width(m.viewport.width - textLen).Render(" "))
+		rendered[i] = cached
 
 		// Last character in the viewport needs not be a newline, or
 		// I will get a weird empty line at the end
-		if i < end-1 {
-			lb.WriteByte('\n')
+		sb.WriteString(cached.text)
+		i = folds.end(i) + 1
+	}
+	m.source.rendered = rendered
+	return sb.String()
+}
+
+// renderedLine is a line rendered by View, reused until anything it shows
+// changes
+type renderedLine struct {
+	key  lineKey
+	text string
+}
+
+// lineKey contains everything that affects how a line looks. The content
+// and colors are only known by their versions, so that comparing keys is
+// cheap.
+type lineKey struct {
+	number           int
+	version          int    // Version of the content
+	highlighted      int    // Version of the colors
+	selStart, selEnd int    // Selection, relative to the line start
+	cursor           int    // Cursor column, -1 if on another line
+	brackets         [2]int // Columns of the matching brackets, or -1
+	folded           bool   // Whether the lines after it are folded
+	theme            int    // Version of the theme
+}
+
+// renderer renders lines, converting every theme style to a lipgloss style
+// only once per frame
+type renderer struct {
+	theme     *themes.Theme
+	number    lipgloss.Style
+	selection themes.Style
+	match     themes.Style
+	fold      themes.Style
+	styles    map[themes.Style]lipgloss.Style
+}
+
+func (m Model) newRenderer() *renderer {
+	return &renderer{
+		theme:     m.theme,
+		number:    m.theme.Get("ui.linenr").Lipgloss(),
+		selection: m.theme.Get("ui.selection"),
+		match:     m.theme.Get("ui.cursor.match"),
+		fold:      m.theme.Get("ui.fold"),
+		styles:    map[themes.Style]lipgloss.Style{},
+	}
+}
+
+// styleAt returns the style of the character at column j
+func (r *renderer) styleAt(key lineKey, j int, colors []themes.Scope) themes.Style {
+	if j == key.cursor {
+		return themes.Style{Reverse: true}
+	}
+	style := r.theme.Style(colors[j])
+	if j == key.brackets[0] || j == key.brackets[1] {
+		// Only the background and modifiers, the syntax colors stay
+		style = themes.Style{Bg: r.match.Bg, Bold: r.match.Bold, Underline: r.match.Underline || themes.NoColor()}.Inherit(style)
+	}
+	if j >= key.selStart && j <= key.selEnd {
+		if themes.NoColor() {
+			style.Reverse = true
+		} else {
+			style.Bg = r.selection.Bg
+		}
+	}
+	return style
+}
+
+// span renders text with the given style
+func (r *renderer) span(sb *strings.Builder, text []byte, style themes.Style) {
+	ls, ok := r.styles[style]
+	if !ok {
+		ls = style.Lipgloss()
+		r.styles[style] = ls
+	}
+	sb.WriteString(ls.Render(string(text)))
+}
+
+// render renders a line. Runs of characters with the same style are
+// rendered together, to keep the number of escape sequences low.
+func (r *renderer) render(key lineKey, line []byte, colors []themes.Scope) string {
+	var sb strings.Builder
+
+	// Write line numbers TODO I could maybe move this inside another component?
+	sb.WriteString(r.number.Render(fmt.Sprintf("%5d  ", key.number+1)))
+	// TODO: Also render the Git Gutter here using these: ▔ ▍
+
+	// Render the cursor and the selection
+	spanStart := 0
+	var spanStyle themes.Style
+	for j := range line {
+		style := r.styleAt(key, j, colors)
+		if j > spanStart && style != spanStyle {
+			r.span(&sb, line[spanStart:j], spanStyle)
+			spanStart = j
 		}
+		spanStyle = style
+	}
+	if spanStart < len(line) {
+		r.span(&sb, line[spanStart:], spanStyle)
+	}
 
-		// Finally write the line to the framebuffer
-		sb.WriteString(lb.String())
+	// If the cursor is on a line end (aka \n), render a whitespace
+	if key.cursor == len(line) {
+		r.span(&sb, []byte(" "), themes.Style{Reverse: true})
+	}
+	if key.folded {
+		r.span(&sb, []byte(" ⋯"), r.fold)
 	}
+
+	// Render the background
+	// bg := r.theme.Get("ui.text").Bg
+	// textLen := lipgloss.Width(sb.String())
+	// sb.WriteString(lipgloss.NewStyle().Background(lipgloss.Color(bg)).Width(m.viewport.width - textLen).Render(" "))
+
 	return sb.String()
 }
 
@@ -563,62 +830,169 @@ func (b *Model) WriteToDisk() tea.Cmd {
 		return footer.ShowError(fmt.Errorf("Error writing to disk."))
 	}
 
-	_, err := b.fd.Seek(0, 0)
+	// Write a snapshot in the background, so that we can keep editing. The
+	// lines are counted there too, as the index of a large file might not
+	// have read all of it yet.
+	fd, path, saving := b.fd, b.Path, b.saving
+	large := b.source.large
+	snapshot := b.source.data.Snapshot()
+	return func() tea.Msg {
+		// Saving again before the last save is done would write both
+		// snapshots at once
+		saving.Lock()
+		defer saving.Unlock()
+
+		var err error
+		if large {
+			err = replaceFile(path, snapshot)
+		} else {
+			err = overwriteFile(fd, snapshot)
+		}
+		if err != nil {
+			return footer.ErrorMsg(err.Error())
+		}
+
+		return footer.StatusMsg(fmt.Sprintf("'%s' written, %dL, %dB",
+			path,
+			countLines(snapshot),
+			snapshot.Len()))
+	}
+}
+
+// countLines returns the number of lines of text, like the line index would
+func countLines(text storage.Text) int {
+	lines := 1
+	buf := make([]byte, 64<<10)
+	for off := 0; off < text.Len(); {
+		n, _ := text.ReadAt(buf, int64(off))
+		if n == 0 {
+			break
+		}
+		lines += bytes.Count(buf[:n], []byte{'\n'})
+		off += n
+	}
+	return lines
+}
+
+// overwriteFile writes the content to the start of the file
+func overwriteFile(fd *os.File, content storage.Text) error {
+	_, err := fd.Seek(0, 0)
 	if err != nil {
-		return footer.ShowError(err)
+		return err
 	}
 
-	_, err = b.fd.Write(b.source.data.Bytes())
+	_, err = content.WriteTo(fd)
+	return err
+}
+
+// replaceFile writes the content to a new file which then replaces the one at
+// path. Used for memory mapped files, whose content can't be overwritten
+// while we're still reading it.
+func replaceFile(path string, content storage.Text) error {
+	info, err := os.Stat(path)
 	if err != nil {
-		return footer.ShowError(err)
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
 	}
+	defer os.Remove(tmp.Name())
 
-	return footer.ShowStatus(fmt.Sprintf("'%s' written, %dL, %dB",
-		b.Path,
-		len(b.source.lines),
-		len(b.source.data.Bytes())))
+	_, err = content.WriteTo(tmp)
+	if err == nil {
+		err = tmp.Chmod(info.Mode())
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
 }
 
-//go:embed syntax/go/highlights.scm
-var highlightsGo []byte
+// languageQueries are the compiled queries of a language
+type languageQueries struct {
+	highlights  *sitter.Query
+	textobjects *sitter.Query // Nil if the language has none
+	indents     *sitter.Query // Nil if the language has none
+	injections  *sitter.Query // Nil if the language has none
+	folds       *sitter.Query // Nil if the language has none
+	// Keywords dedenting their line, like "case", from the indents query
+	outdents map[string]bool
+}
 
-//go:embed syntax/rust/highlights.scm
-var highlightsRust []byte
+// Compiled queries by language. Compiling them takes a while, and injected
+// languages need them on every parse.
+var (
+	compiledQueries   = map[*syntax.Language]languageQueries{}
+	compiledQueriesMu sync.Mutex
+)
+
+// loadQueries compiles the queries of a language. Only the highlights are
+// required.
+func loadQueries(language *syntax.Language) (languageQueries, error) {
+	compiledQueriesMu.Lock()
+	defer compiledQueriesMu.Unlock()
+	if q, ok := compiledQueries[language]; ok {
+		return q, nil
+	}
 
-//go:embed syntax/nix/highlights.scm
-var highlightsNix []byte
+	var q languageQueries
+	for name, query := range map[string]**sitter.Query{
+		"highlights":  &q.highlights,
+		"textobjects": &q.textobjects,
+		"indents":     &q.indents,
+		"injections":  &q.injections,
+		"folds":       &q.folds,
+	} {
+		source, err := language.Query(name)
+		if name != "highlights" && errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return q, err
+		}
+		*query, err = sitter.NewQuery(source, language.SitterLanguage())
+		if err != nil {
+			return q, fmt.Errorf("%s %s: %w", language.Name, name, err)
+		}
+		if name == "indents" {
+			q.outdents = outdentKeywords(source)
+		}
+	}
+	compiledQueries[language] = q
+	return q, nil
+}
 
 // InitTree parses the source code using treesitter and generates
 // a syntax tree for it.
-func InitTree(sourceCode *SourceCode, ext string) tea.Cmd {
+func InitTree(sourceCode *SourceCode, language *syntax.Language) tea.Cmd {
+	// Parse a snapshot, the source may change while we're parsing
+	snapshot := sourceCode.data.Snapshot()
+	version, langs := sourceCode.version, sourceCode.langs
 	return func() tea.Msg {
-		var lang *sitter.Language
-		var highlights []byte
-		if ext == ".go" {
-			lang = golang.GetLanguage()
-			highlights = highlightsGo
-		} else if ext == ".rs" {
-			lang = rust.GetLanguage()
-			highlights = highlightsRust
-		} else if ext == ".nix" {
-			lang = nix.GetLanguage()
-			highlights = highlightsNix
-		} else {
-			log.Printf("[Treesitter] Unsupported language: %s", ext)
-			return nil
-		}
-		tree, _ := sitter.ParseCtx(context.Background(), sourceCode.data.Bytes(), lang)
-
-		q, err := sitter.NewQuery(highlights, lang)
+		lang := language.SitterLanguage()
+		q, err := loadQueries(language)
 		if err != nil {
-			log.Panic(err)
+			return footer.ErrorMsg(err.Error())
 		}
 
+		tree, colors, _ := parse(context.Background(), snapshot, lang, nil, q, langs)
+
 		// Save the current tree and syntax highlighting
 		return TreeInitMsg{
-			tree:    tree,
 			lang:    lang,
 			queries: q,
+			parse: ParseDoneMsg{
+				source:  sourceCode,
+				version: version,
+				tree:    tree,
+				colors:  colors,
+			},
 		}
 	}
 }
@@ -632,14 +1006,26 @@ func (m *Model) OpenFile(path string) tea.Cmd {
 		return footer.ShowError(err)
 	}
 
-	content, err := io.ReadAll(fd)
+	info, err := fd.Stat()
 	if err != nil {
 		return footer.ShowError(err)
 	}
-	extension := filepath.Ext(path)
 
+	// Large files are memory mapped instead of read. The mapping is never
+	// unmapped, as snapshots being saved in the background might still use
+	// it.
+	var content []byte
+	large := info.Size() > m.config.Editor.LargeFileThreshold
+	if large {
+		content, err = mapFile(fd, info.Size())
+	} else {
+		content, err = io.ReadAll(fd)
+	}
+	if err != nil {
+		return footer.ShowError(err)
+	}
 	source := SourceCode{}
-	source.SetSource(content)
+	source.SetSource(content, large)
 
 	m.source = &source
 	m.viewport.offset = 0
@@ -647,9 +1033,26 @@ func (m *Model) OpenFile(path string) tea.Cmd {
 	m.fd = fd
 	m.modified = false
 
+	if large {
+		return footer.ShowStatus("Large file: syntax highlighting disabled")
+	}
+	language := m.langs.Detect(path, content)
+	if language == nil {
+		log.Printf("[Treesitter] Unsupported language: %s", path)
+		return nil
+	}
+	source.indent = language.IndentUnit()
+	source.pairs = language.AutoPairs()
+	source.comment, source.blockComment = language.CommentToken, language.BlockComment
+	source.langs = m.langs
 	return tea.Batch(
-		InitTree(&source, extension))
+		InitTree(&source, language))
+
+}
 
+// LargeFile reports whether the buffer is opened in large file mode
+func (m Model) LargeFile() bool {
+	return m.source != nil && m.source.large
 }
 
 // Name returns the title of the buffer window to display
@@ -661,14 +1064,14 @@ func (b Model) Name() string {
 func (source *SourceCode) cursorDown(n int) {
 	index, _, _ := source.CurrentLine()
 
-	nextIndex := clamp(index+n, 0, len(source.lines))
-	nextLine := source.lines[nextIndex]
+	nextIndex := source.moveVisible(index, n)
+	nextLine := source.Line(nextIndex)
 
 	// Remembers the cursor horizontal position
 	x := 0
 	i := 0
 	for i < nextLine.end-nextLine.start {
-		char := source.data.GetAbs(nextLine.start + i)
+		char := source.data.ByteAt(nextLine.start + i)
 		if char == '\t' {
 			x += 4
 		} else {
@@ -690,14 +1093,14 @@ func (source *SourceCode) cursorDown(n int) {
 func (source *SourceCode) cursorUp(n int) {
 	index, _, _ := source.CurrentLine()
 
-	nextIndex := clamp(index-n, 0, len(source.lines))
-	nextLine := source.lines[nextIndex]
+	nextIndex := source.moveVisible(index, -n)
+	nextLine := source.Line(nextIndex)
 
 	// Remembers the cursor horizontal position
 	x := 0
 	i := 0
 	for i < nextLine.end-nextLine.start {
-		char := source.data.GetAbs(nextLine.start + i)
+		char := source.data.ByteAt(nextLine.start + i)
 		if char == '\t' {
 			x += 4
 		} else {
@@ -0,0 +1,1026 @@
+package buffer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Ardelean-Calin/elmo/pkg/buffer/syntax"
+	"github.com/Ardelean-Calin/elmo/pkg/config"
+	"github.com/Ardelean-Calin/elmo/pkg/themes"
+	"github.com/Ardelean-Calin/elmo/ui/components/footer"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/matryer/is"
+	"github.com/muesli/termenv"
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// newTestModel opens content as if it was the file at path, with syntax
+// highlighting done synchronously
+func newTestModel(tb testing.TB, path string, content []byte) Model {
+	tb.Helper()
+	langs, err := syntax.Load()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	m := New(config.Default(), langs, themes.Default())
+	m.source = &SourceCode{}
+	m.source.SetSource(content, false)
+	m.viewport = Viewport{width: 120, height: 50}
+
+	if lang := langs.Detect(path, content); lang != nil {
+		q, err := loadQueries(lang)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		m.source.lang, m.source.queries = lang.SitterLanguage(), q
+		m.source.indent, m.source.langs = lang.IndentUnit(), langs
+		m.source.pairs = lang.AutoPairs()
+		m.source.comment, m.source.blockComment = lang.CommentToken, lang.BlockComment
+		m.source.tree, m.source.colors, _ = parse(context.Background(), m.source.data.Snapshot(), m.source.lang, nil, q, langs)
+	}
+	return m
+}
+
+// typeParsed is typeKeys, delivering the background parse after every key
+// like a slow typist would
+func typeParsed(m Model, keys string) Model {
+	for _, r := range keys {
+		m = typeKeys(m, string(r))
+		if cmd := m.source.Reparse(); cmd != nil {
+			m, _ = m.Update(cmd())
+		}
+	}
+	return m
+}
+
+// withColors renders in true color for the duration of the test
+func withColors(tb testing.TB) {
+	profile := lipgloss.ColorProfile()
+	lipgloss.SetColorProfile(termenv.TrueColor)
+	tb.Cleanup(func() { lipgloss.SetColorProfile(profile) })
+}
+
+var escapes = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// plain removes the escape sequences from s
+func plain(s string) string {
+	return escapes.ReplaceAllString(s, "")
+}
+
+func TestView(t *testing.T) {
+	is := is.New(t)
+	withColors(t)
+
+	content := "package main\n\n// Greet says hi\nfunc main() {\n\tprintln(\"hi ü\")\n}"
+	m := newTestModel(t, "main.go", []byte(content))
+	m.source.SetCursor(3)
+
+	view := m.View()
+	var want []string
+	for i, line := range strings.Split(content, "\n") {
+		if i == 0 {
+			// The cursor gets rendered as its own span
+			line = "pac" + "k" + "age main"
+		}
+		want = append(want, fmt.Sprintf("%5d  %s", i+1, strings.ReplaceAll(line, "\t", "    ")))
+	}
+	is.Equal(plain(view), strings.Join(want, "\n"))
+
+	// Spans: the comment is a single run of the same style
+	comment := m.theme.Get("comment").Lipgloss().Render("// Greet says hi")
+	is.True(strings.Contains(view, comment))
+
+	// Cached lines are reused, edits render the line again
+	is.Equal(m.View(), view)
+	m.source.Insert([]byte("x"))
+	is.True(m.View() != view)
+	is.Equal(plain(m.View())[:len("    1  pacxkage main")], "    1  pacxkage main")
+	is.Equal(len(m.source.rendered), 6)
+
+	// Theme changes render everything again
+	m.source.SetCursor(0)
+	before := m.View()
+	m.theme.SetPalette(map[string]string{"base0E": "#ff0000"})
+	is.True(m.View() != before)
+	is.True(strings.Contains(m.View(), m.theme.Get("keyword").Lipgloss().Render("func")))
+
+	// So do new colors, without edits
+	before = m.View()
+	plainColors := make([]themes.Scope, m.source.data.Len())
+	is.True(m.source.applyParse(ParseDoneMsg{source: m.source, version: m.source.version, tree: m.source.tree, colors: plainColors}))
+	is.True(m.View() != before)
+	is.Equal(plain(m.View()), plain(before))
+}
+
+func TestLargeFile(t *testing.T) {
+	is := is.New(t)
+
+	var content []byte
+	for i := 0; i < 100000; i++ {
+		content = fmt.Appendf(content, "line %d\n", i)
+	}
+	m := newTestModel(t, "huge.txt", nil)
+	m.source.SetSource(content, true)
+	m.viewport.height = 3
+
+	// Lines are found as they are shown, edited and scrolled through
+	is.True(strings.Contains(plain(m.View()), "line 2"))
+	m = typeKeys(m, "ix")
+	is.True(strings.Contains(plain(m.View()), "xline 0"))
+	m.scroll(200000)
+	view := plain(m.View())
+	is.True(strings.Contains(view, "line 99999") && !strings.Contains(view, "line 99997"))
+	is.Equal(m.source.LineCount(), 100001)
+
+	// Nor is there anything to fold
+	m.source.SetSource([]byte("a\n\tb\n"), true)
+	m.Mode = Normal
+	m = typeKeys(m, "zc")
+	is.Equal(len(m.source.folds), 0)
+}
+
+func TestWriteToDisk(t *testing.T) {
+	is := is.New(t)
+
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	is.NoErr(os.WriteFile(path, []byte("a\nb\n"), 0644))
+	m := newTestModel(t, path, nil)
+	_ = m.OpenFile(path)
+	m = typeKeys(m, "ix\n")
+
+	// Saves running at once write one after the other
+	saves := []tea.Cmd{m.WriteToDisk(), m.WriteToDisk()}
+	var wg sync.WaitGroup
+	msgs := make([]tea.Msg, len(saves))
+	for i, save := range saves {
+		wg.Add(1)
+		go func(i int, save tea.Cmd) {
+			defer wg.Done()
+			msgs[i] = save()
+		}(i, save)
+	}
+	wg.Wait()
+	content, err := os.ReadFile(path)
+	is.NoErr(err)
+	is.Equal(string(content), "x\na\nb\n")
+	is.Equal(msgs[0], footer.StatusMsg(fmt.Sprintf("'%s' written, 4L, 6B", path)))
+}
+
+// BenchmarkView measures the cost of a frame on a full screen Go file
+func BenchmarkView(b *testing.B) {
+	withColors(b)
+	content, err := os.ReadFile("buffer.go")
+	if err != nil {
+		b.Fatal(err)
+	}
+	m := newTestModel(b, "buffer.go", content)
+	m.viewport = Viewport{offset: 400, width: 200, height: 60}
+
+	b.Run("Uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			m.source.rendered = nil
+			_ = m.View()
+		}
+	})
+	b.Run("Cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = m.View()
+		}
+	})
+	b.Run("Scrolling", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			m.viewport.offset = 400 + i%2
+			_ = m.View()
+		}
+	})
+}
+
+// BenchmarkAutoPairs measures typing a pair in a large Go file, which looks
+// for strings and comments in the tree of the edited content
+func BenchmarkAutoPairs(b *testing.B) {
+	content, err := os.ReadFile("buffer.go")
+	if err != nil {
+		b.Fatal(err)
+	}
+	content = bytes.Repeat(content, 20)
+	typing := func(b *testing.B, m Model) {
+		m.Mode = Insert
+		m.source.SetCursor(bytes.Index(content, []byte("\n\n")) + 1)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			m = typeKeys(m, "(")
+			m, _ = m.Update(tea.KeyMsg{Type: tea.KeyBackspace})
+		}
+	}
+
+	b.Run("Parsed", func(b *testing.B) {
+		typing(b, newTestModel(b, "buffer.go", content))
+	})
+	// Before the first parse is delivered
+	b.Run("Unparsed", func(b *testing.B) {
+		m := newTestModel(b, "buffer.go", content)
+		m.source.tree = nil
+		typing(b, m)
+	})
+}
+
+// selected returns the selected text
+func selected(m Model) string {
+	start, end := m.source.GetSelection()
+	return string(m.source.GetSlice(start, end+1))
+}
+
+func TestSyntaxSelection(t *testing.T) {
+	is := is.New(t)
+
+	content := "package main\n\nfunc main() {\n\tfmt.Println(a, bb, c)\n}\n"
+	m := newTestModel(t, "main.go", []byte(content))
+	m.source.SetCursor(strings.Index(content, "bb"))
+	key := func(k string) {
+		m, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k), Alt: true})
+	}
+
+	key("o")
+	is.Equal(selected(m), "bb")
+	key("o")
+	is.Equal(selected(m), "(a, bb, c)")
+	key("o")
+	is.Equal(selected(m), "fmt.Println(a, bb, c)")
+
+	// Shrinking goes back the same way
+	key("i")
+	is.Equal(selected(m), "(a, bb, c)")
+	key("i")
+	is.Equal(selected(m), "bb")
+	key("i")
+	is.Equal(selected(m), "b")
+	is.Equal(m.source.cursor, strings.Index(content, "bb"))
+
+	// Siblings
+	key("o")
+	key("n")
+	is.Equal(selected(m), "c")
+	key("p")
+	key("p")
+	is.Equal(selected(m), "a")
+	key("p") // First argument, continues with the function name
+	is.Equal(selected(m), "fmt.Println")
+
+	// Without a stack, shrinking selects the first child
+	key("i")
+	is.Equal(selected(m), "fmt")
+
+	// Edited content gets parsed again
+	m.source.SetCursor(strings.Index(content, "bb"))
+	m.source.Insert([]byte("b"))
+	key("o")
+	is.Equal(selected(m), "bbb")
+}
+
+// typeKeys sends every rune of keys as a key press
+func typeKeys(m Model, keys string) Model {
+	for _, r := range keys {
+		msg := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}}
+		switch r {
+		case '\n':
+			msg = tea.KeyMsg{Type: tea.KeyEnter}
+		case ' ':
+			msg = tea.KeyMsg{Type: tea.KeySpace, Runes: []rune{r}}
+		}
+		m, _ = m.Update(msg)
+	}
+	return m
+}
+
+func TestTextObjects(t *testing.T) {
+	is := is.New(t)
+
+	content := `package main
+
+// Point is a point
+// on a plane
+type Point struct {
+	X, Y int
+}
+
+func add(a int, b int) int {
+	return a + b
+}
+
+func main() {
+	add(1, 2)
+}
+`
+	m := newTestModel(t, "main.go", []byte(content))
+	at := func(s string) { m.source.SetCursor(strings.Index(content, s)) }
+
+	at("+ b")
+	m = typeKeys(m, "mif")
+	is.Equal(selected(m), "{\n\treturn a + b\n}")
+	m = typeKeys(m, "maf")
+	is.Equal(selected(m), "func add(a int, b int) int {\n\treturn a + b\n}")
+	at("+ b")
+	m = typeKeys(m, "mic") // Not inside a type
+	is.Equal(selected(m), "+")
+
+	at("X, Y")
+	m = typeKeys(m, "mic")
+	is.Equal(selected(m), "{\n\tX, Y int\n}")
+	m = typeKeys(m, "mac")
+	is.Equal(selected(m), "type Point struct {\n\tX, Y int\n}")
+
+	at("b int)")
+	m = typeKeys(m, "mia")
+	is.Equal(selected(m), "b int")
+	at("a int,")
+	m = typeKeys(m, "maa")
+	is.Equal(selected(m), "a int,")
+	at("2)")
+	m = typeKeys(m, "mia")
+	is.Equal(selected(m), "2")
+
+	// Consecutive comments are one object around, every line inside
+	at("plane")
+	m = typeKeys(m, "mio")
+	is.Equal(selected(m), "// on a plane")
+	m = typeKeys(m, "mao")
+	is.Equal(selected(m), "// Point is a point\n// on a plane")
+
+	// Jumping between functions
+	m.source.SetCursor(0)
+	m = typeKeys(m, "]f")
+	is.Equal(selected(m), "func add(a int, b int) int {\n\treturn a + b\n}")
+	m = typeKeys(m, "]f")
+	is.True(strings.HasPrefix(selected(m), "func main()"))
+	m = typeKeys(m, "]f") // There is no next one
+	is.True(strings.HasPrefix(selected(m), "func main()"))
+	m = typeKeys(m, "[f")
+	is.True(strings.HasPrefix(selected(m), "func add("))
+
+	// Unknown sequences are dropped, without running their keys
+	m.source.SetCursor(0)
+	m = typeKeys(m, "mx")
+	is.Equal(m.pending, "")
+	is.Equal(m.source.data.Len(), len(content))
+	m = typeKeys(m, "m")
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	m = typeKeys(m, "i")
+	is.Equal(m.Mode, Insert)
+}
+
+func TestAutoIndent(t *testing.T) {
+	is := is.New(t)
+
+	for _, tc := range []struct {
+		path, typed, want string
+	}{
+		{
+			"main.go",
+			"package main\n\nfunc main() {\nif x {\nfoo()\n}\n}\n",
+			"package main\n\nfunc main() {\n\tif x {\n\t\tfoo()\n\t}\n}\n",
+		},
+		{
+			"lib.rs",
+			"fn main() {\nlet x = [\n1,\n];\n}",
+			"fn main() {\n    let x = [\n        1,\n    ];\n}",
+		},
+		{
+			"app.py",
+			"def f(x):\nreturn (\n1\n)\n",
+			"def f(x):\n    return (\n        1\n    )\n    ",
+		},
+		// Without indents query, the previous line is copied
+		{
+			"notes.txt",
+			"a {\nb\n  c\nd\n}\n",
+			"a {\n\tb\n\t  c\n\t  d\n}\n",
+		},
+	} {
+		m := newTestModel(t, tc.path, nil)
+		// Typed as is, without auto-pairs
+		m.source.pairs = map[byte]byte{}
+		m.Mode = Insert
+		m = typeKeys(m, tc.typed)
+		is.Equal(string(m.source.data.Bytes()), tc.want) // tc.path
+	}
+
+	// Lines get indented from the tree, no matter what's above
+	content := "func main() {\n\tif x {\n\t\tfoo()\n\t}\n}\n"
+	m := newTestModel(t, "main.go", []byte(content))
+	m.Mode = Insert
+	m.source.SetCursor(strings.Index(content, "foo()"))
+	m = typeKeys(m, "\n")
+	is.Equal(string(m.source.data.Bytes()), "func main() {\n\tif x {\n\t\t\n\t\tfoo()\n\t}\n}\n")
+
+	// Parsing again from the edited tree gives the tree of the new content
+	tree, ok := parseTree(context.Background(), m.source.data.Snapshot(), m.source.lang, nil)
+	is.True(ok)
+	is.Equal(m.source.freshTree().String(), tree.RootNode().String())
+
+	// Typing a case lines it up with its switch, as gofmt does, until it
+	// turns out to be another word
+	content = "package main\n\nfunc main() {\n\t\n}\n"
+	m = newTestModel(t, "main.go", []byte(content))
+	m.Mode = Insert
+	m.source.SetCursor(strings.Index(content, "\t\n") + 1)
+	m = typeKeys(m, "switch x {\ncase 1:\nbar()\ndefault:\ncases := 2")
+	is.Equal(string(m.source.data.Bytes()), "package main\n\nfunc main() {\n\tswitch x {\n\tcase 1:\n\t\tbar()\n\tdefault:\n\t\tcases := 2\n\t}\n}\n")
+	is.Equal(m.source.queries.outdents, map[string]bool{"case": true, "default": true})
+
+	// Other words don't need the tree, which isn't parsed for them
+	m = typeKeys(m, "\n")
+	parsed := m.source.parsed
+	m = typeKeys(m, "foo")
+	is.Equal(m.source.parsed, parsed)
+
+	// Nor is all of it parsed before the first parse is delivered
+	m.source.tree = nil
+	m = typeKeys(m, "\ncase")
+	is.True(m.source.tree == nil)
+	is.True(strings.HasSuffix(string(m.source.data.Bytes()), "\t\tfoo\n\t\tcase\n\t}\n}\n"))
+}
+
+func TestPredicates(t *testing.T) {
+	is := is.New(t)
+
+	content := "package main\n\nfunc main() {\n\tFoo(bar, bar, Baz)\n}\n"
+	m := newTestModel(t, "main.go", []byte(content))
+	captured := func(source string) []string {
+		query, err := sitter.NewQuery([]byte(source), m.source.lang)
+		is.NoErr(err)
+		var names []string
+		for _, r := range m.source.captureRanges(m.source.freshTree(), query, "name") {
+			names = append(names, content[r.start:r.end])
+		}
+		return names
+	}
+
+	is.Equal(captured(`((identifier) @name (#match? @name "^[A-Z]"))`), []string{"Foo", "Baz"})
+	is.Equal(captured(`((identifier) @name (#not-match? @name "^[A-Z]"))`), []string{"main", "bar", "bar"})
+	is.Equal(captured(`((identifier) @name (#eq? @name "bar"))`), []string{"bar", "bar"})
+	is.Equal(captured(`((identifier) @name (#not-eq? @name "bar") (#not-eq? @name "main"))`), []string{"Foo", "Baz"})
+	is.Equal(captured(`(argument_list (identifier) @name . (identifier) @other (#eq? @name @other))`), []string{"bar"})
+	is.Equal(captured(`((identifier) @name (#match? @name "("))`), nil)
+}
+
+func TestInjections(t *testing.T) {
+	is := is.New(t)
+
+	// scopeAt returns the scope of the first character of s inside content
+	scopeAt := func(m Model, content, s string) string {
+		return m.source.colors[strings.Index(content, s)].Name()
+	}
+
+	content := "FROM alpine\nRUN echo \"hi\" && ls $HOME\n"
+	m := newTestModel(t, "Dockerfile", []byte(content))
+	is.Equal(scopeAt(m, content, "RUN"), "keyword")
+	is.Equal(scopeAt(m, content, "echo"), "function.builtin")
+	is.Equal(scopeAt(m, content, "\"hi\""), "string")
+	is.Equal(scopeAt(m, content, "$HOME"), "operator")
+
+	// The language named by a comment, without the quotes of the string
+	content = "package main\n\nvar q = /* bash */ `echo $HOME`\nvar r = /* unknown */ `echo`\n\nfunc main() {\n\tcmd(/* sh */ \"ls\")\n}\n"
+	m = newTestModel(t, "main.go", []byte(content))
+	is.Equal(scopeAt(m, content, "`echo $HOME`"), "string")
+	is.Equal(scopeAt(m, content, "echo $HOME"), "function.builtin")
+	is.Equal(scopeAt(m, content, "`echo`"), "string")
+	is.Equal(scopeAt(m, content, "echo`"), "string")
+	is.Equal(scopeAt(m, content, "ls"), "function")
+
+	// The scripts of Nix derivations are shell
+	content = "{\n  buildPhase = \"if true; then make; fi\";\n  description = \"if only\";\n}\n"
+	m = newTestModel(t, "flake.nix", []byte(content))
+	is.Equal(scopeAt(m, content, "\"if true"), "string")
+	is.Equal(scopeAt(m, content, "if true"), "keyword")
+	is.Equal(scopeAt(m, content, "make"), "function")
+	is.Equal(scopeAt(m, content, "if only"), "string")
+
+	is.Equal(languageName(" /* SQL */"), "sql")
+	is.Equal(languageName("# bash"), "bash")
+	is.Equal(languageName("// regex"), "regex")
+}
+
+func TestFolding(t *testing.T) {
+	is := is.New(t)
+
+	content := "package main\n\nfunc add(a int, b int) int {\n\treturn a + b\n}\n\nfunc main() {\n\tif true {\n\t\tprintln(add(1, 2))\n\t}\n}\n"
+	m := newTestModel(t, "main.go", []byte(content))
+	m.source.SetCursor(strings.Index(content, "println"))
+
+	// The innermost block gets folded first, then the one around it
+	m = typeKeys(m, "zc")
+	is.Equal(m.source.closedFolds(), folding{{7, 9}})
+	is.Equal(m.source.lineOf(m.source.cursor), 7)
+	m = typeKeys(m, "zc")
+	is.Equal(m.source.closedFolds(), folding{{7, 9}, {6, 10}})
+
+	// A folded function is a single line, with a marker
+	lines := strings.Split(plain(m.View()), "\n")
+	is.Equal(len(lines), 8)
+	is.Equal(lines[6], "    7  func main() { ⋯")
+	is.Equal(lines[7], "   12  ")
+
+	// j and k skip over folds
+	m.source.SetCursor(0)
+	m = typeKeys(m, "jjjjjj")
+	is.Equal(m.source.lineOf(m.source.cursor), 6)
+	m = typeKeys(m, "j")
+	is.Equal(m.source.lineOf(m.source.cursor), 11)
+	m = typeKeys(m, "k")
+	is.Equal(m.source.lineOf(m.source.cursor), 6)
+
+	// zo opens the outer fold, the inner one stays closed
+	m = typeKeys(m, "zo")
+	is.Equal(m.source.closedFolds(), folding{{7, 9}})
+	m = typeKeys(m, "za")
+	is.Equal(m.source.closedFolds(), folding{{7, 9}, {6, 10}})
+	m = typeKeys(m, "zR")
+	is.Equal(len(m.source.closedFolds()), 0)
+	m = typeKeys(m, "zM")
+	is.Equal(len(strings.Split(m.View(), "\n")), 6)
+
+	// Clicks land on the line shown on that row
+	m, _ = m.Update(tea.MouseMsg{X: 7, Y: 4, Button: tea.MouseButtonLeft, Action: tea.MouseActionPress})
+	is.Equal(m.source.lineOf(m.source.cursor), 6)
+	m, _ = m.Update(tea.MouseMsg{X: 7, Y: 5, Button: tea.MouseButtonLeft, Action: tea.MouseActionPress})
+	is.Equal(m.source.lineOf(m.source.cursor), 11)
+
+	// Edits move the folds after them, and open the ones they touch
+	m.source.SetCursor(0)
+	m.source.Insert([]byte("// Package main\n"))
+	is.Equal(m.source.closedFolds(), folding{{3, 5}, {7, 11}, {8, 10}})
+	m.source.SetCursor(strings.Index(string(m.source.data.Bytes()), "return"))
+	m.source.Insert([]byte("x"))
+	is.Equal(m.source.closedFolds(), folding{{7, 11}, {8, 10}})
+
+	// Without a folds query, more indented lines get folded
+	content = "a:\n  b: 1\n\n  c:\n    d: 2\ne: 3\n"
+	m = newTestModel(t, "notes.txt", []byte(content))
+	is.Equal(m.source.indentFolds(), []lineRange{{3, 4}, {0, 4}})
+	m.source.SetCursor(strings.Index(content, "b:"))
+	m = typeKeys(m, "zc")
+	is.Equal(m.source.closedFolds(), folding{{0, 4}})
+	is.Equal(plain(m.View()), "    1  a: ⋯\n    6  e: 3\n    7  ")
+}
+
+func TestBrackets(t *testing.T) {
+	is := is.New(t)
+
+	content := "func main() {\n\tprintln(\"(\", f(a[1]))\n}\n"
+	m := newTestModel(t, "main.go", []byte(content))
+	root := m.source.syntaxTree()
+	call, last := strings.Index(content, "println(")+len("println"), strings.LastIndex(content, ")")
+	is.Equal(m.source.matchingBracket(root, call), last)
+	is.Equal(m.source.matchingBracket(root, last), call)
+	// Brackets inside strings aren't part of the code around them
+	is.Equal(m.source.matchingBracket(root, strings.Index(content, "(\"")+1), -1)
+	open, close := m.source.enclosingBrackets(root, strings.Index(content, "a["))
+	is.Equal(string(m.source.data.Slice(open, close+1)), "(a[1])")
+
+	// The pair is highlighted along with the cursor
+	m.source.SetCursor(strings.Index(content, "1"))
+	_ = m.View()
+	is.Equal(m.source.rendered[1].key.brackets, [2]int{strings.Index(content, "[") - 14, strings.Index(content, "]") - 14})
+
+	// mm jumps to the matching bracket, or to the end of the pair around
+	m.source.SetCursor(strings.Index(content, "{"))
+	m = typeKeys(m, "mm")
+	is.Equal(m.source.cursor, strings.LastIndex(content, "}"))
+	m = typeKeys(m, "mm")
+	is.Equal(m.source.cursor, strings.Index(content, "{"))
+	m.source.SetCursor(strings.Index(content, "a["))
+	m = typeKeys(m, "mm")
+	is.Equal(m.source.cursor, last-1)
+
+	// Without a grammar, the text gets scanned
+	content = "a (b [c] d) ]e"
+	m = newTestModel(t, "notes.txt", []byte(content))
+	is.Equal(m.source.matchingBracket(nil, 2), 10)
+	is.Equal(m.source.matchingBracket(nil, 10), 2)
+	is.Equal(m.source.matchingBracket(nil, 12), -1)
+	open, close = m.source.enclosingBrackets(nil, strings.Index(content, "d"))
+	is.Equal([]int{open, close}, []int{2, 10})
+	open, _ = m.source.enclosingBrackets(nil, strings.Index(content, "e"))
+	is.Equal(open, -1)
+}
+
+func TestUndo(t *testing.T) {
+	is := is.New(t)
+
+	content := "hello world"
+	m := newTestModel(t, "notes.txt", []byte(content))
+	text := func() string { return string(m.source.data.Bytes()) }
+
+	// A visit to insert mode is undone at once, commands on their own
+	m = typeKeys(m, "iab")
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	is.Equal(text(), "abhello world")
+	m.source.SetCursor(2)
+	m = typeKeys(m, "d")
+	is.Equal(text(), "abello world")
+	m = typeKeys(m, "u")
+	is.Equal(text(), "abhello world")
+	is.Equal(m.source.cursor, 2)
+	m = typeKeys(m, "u")
+	is.Equal(text(), content)
+	is.Equal(m.source.cursor, 0)
+	m = typeKeys(m, "u")
+	is.Equal(text(), content)
+
+	// Undone edits are redone until something else changes
+	m = typeKeys(m, "UU")
+	is.Equal(text(), "abello world")
+	m = typeKeys(m, "uu")
+	is.Equal(text(), content)
+	m = typeKeys(m, "U")
+	m.source.SetCursor(0)
+	m = typeKeys(m, "d")
+	m = typeKeys(m, "U")
+	is.Equal(text(), "bhello world")
+}
+
+func TestSurround(t *testing.T) {
+	is := is.New(t)
+
+	content := "call(a, [b], \"c\")"
+	m := newTestModel(t, "notes.txt", []byte(content))
+	text := func() string { return string(m.source.data.Bytes()) }
+	m.source.SetCursor(strings.Index(content, "a,"))
+
+	m = typeKeys(m, "ms(")
+	is.Equal(text(), "call((a), [b], \"c\")")
+	is.Equal(selected(m), "(a)")
+	m = typeKeys(m, "mr([")
+	is.Equal(text(), "call([a], [b], \"c\")")
+	is.Equal(selected(m), "[a]")
+	m = typeKeys(m, "md]")
+	is.Equal(text(), content)
+	is.Equal(selected(m), "a")
+
+	// Every command is undone at once
+	m = typeKeys(m, "ms\"")
+	m = typeKeys(m, "u")
+	is.Equal(text(), content)
+
+	// Nested pairs are skipped, quotes and other characters pair with
+	// themselves
+	m.source.SetCursor(strings.Index(content, "c\""))
+	m = typeKeys(m, "md(")
+	is.Equal(text(), "calla, [b], \"c\"")
+	m = typeKeys(m, "mr\"*")
+	is.Equal(text(), "calla, [b], *c*")
+	m = typeKeys(m, "md*")
+	is.Equal(text(), "calla, [b], c")
+	is.Equal(selected(m), "c")
+	m = typeKeys(m, "md)")
+	is.Equal(text(), "calla, [b], c")
+}
+
+func TestAutoPairs(t *testing.T) {
+	is := is.New(t)
+
+	m := newTestModel(t, "main.go", []byte("package main\n\n"))
+	text := func() string { return string(m.source.data.Bytes()) }
+	m.source.SetCursor(m.source.data.Len())
+	m.Mode = Insert
+
+	// Closing characters get typed over
+	m = typeKeys(m, "func f(a")
+	is.Equal(text(), "package main\n\nfunc f(a)")
+	m = typeKeys(m, ") {\n")
+	is.Equal(text(), "package main\n\nfunc f(a) {\n\t\n}")
+
+	// Nothing gets paired inside strings and comments, once they are parsed
+	m = typeParsed(m, "x := \"(")
+	is.Equal(text(), "package main\n\nfunc f(a) {\n\tx := \"(\"\n}")
+	m = typeParsed(m, "\" // (")
+	is.Equal(text(), "package main\n\nfunc f(a) {\n\tx := \"(\" // (\n}")
+
+	// Empty pairs get deleted at once
+	m = typeKeys(m, "\n[")
+	is.Equal(text(), "package main\n\nfunc f(a) {\n\tx := \"(\" // (\n\t[]\n}")
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyBackspace})
+	is.Equal(text(), "package main\n\nfunc f(a) {\n\tx := \"(\" // (\n\t\n}")
+
+	// Without a parse delivered yet, nothing is a string
+	m = newTestModel(t, "main.go", []byte("package main\n\nvar x = \""))
+	m.source.SetCursor(m.source.data.Len())
+	m.source.tree = nil
+	m.Mode = Insert
+	m = typeKeys(m, "(")
+	is.True(m.source.tree == nil)
+	is.Equal(text(), "package main\n\nvar x = \"()")
+
+	// Not in front of words, nor for apostrophes
+	m = newTestModel(t, "notes.txt", []byte("word"))
+	m.Mode = Insert
+	m = typeKeys(m, "(")
+	is.Equal(text(), "(word")
+	m.source.SetCursor(m.source.data.Len())
+	m = typeKeys(m, "'s '")
+	is.Equal(text(), "(word's ''")
+
+	// Languages choose their pairs
+	m = newTestModel(t, "main.rs", nil)
+	m.Mode = Insert
+	m = typeKeys(m, "<'")
+	is.Equal(text(), "<'")
+}
+
+func TestComments(t *testing.T) {
+	is := is.New(t)
+
+	content := "func f() {\n\ta()\n\n\t\tb()\n}\n"
+	m := newTestModel(t, "main.go", []byte(content))
+	text := func() string { return string(m.source.data.Bytes()) }
+	toggle := func() {
+		m, _ = m.Update(tea.KeyMsg{Type: tea.KeyCtrlC})
+	}
+	m.source.setSelection(selection{anchor: strings.Index(content, "a()"), end: strings.Index(content, "b()"), cursor: strings.Index(content, "b()")})
+
+	// Comments line up, blank lines stay blank
+	toggle()
+	is.Equal(text(), "func f() {\n\t// a()\n\n\t// \tb()\n}\n")
+	is.Equal(selected(m), "// a()\n\n\t// \tb")
+	toggle()
+	is.Equal(text(), content)
+
+	// Lines which aren't all comments get commented
+	m.source.SetCursor(strings.Index(content, "a()"))
+	toggle()
+	m.source.setSelection(selection{anchor: 0, end: strings.Index(text(), "b()")})
+	toggle()
+	is.Equal(text(), "// func f() {\n// \t// a()\n\n// \t\tb()\n}\n")
+	m = typeKeys(m, "u")
+	is.Equal(text(), "func f() {\n\t// a()\n\n\t\tb()\n}\n")
+
+	// Without line comments, the lines go inside a block comment
+	m.source.comment = ""
+	m.source.setSelection(selection{anchor: strings.Index(text(), "b()"), end: strings.Index(text(), "}")})
+	toggle()
+	is.Equal(text(), "func f() {\n\t// a()\n\n\t\t/* b()\n} */\n")
+	toggle()
+	is.Equal(text(), "func f() {\n\t// a()\n\n\t\tb()\n}\n")
+
+	// Nothing happens without comment tokens
+	m = newTestModel(t, "notes.txt", []byte(content))
+	toggle()
+	is.Equal(text(), content)
+}
+
+func TestIndentCommands(t *testing.T) {
+	is := is.New(t)
+
+	content := "func f() {\nif x {\n\n  a()\n}\n}\n"
+	m := newTestModel(t, "main.go", []byte(content))
+	text := func() string { return string(m.source.data.Bytes()) }
+	m.source.setSelection(selection{anchor: strings.Index(content, "if"), end: strings.Index(content, "}")})
+
+	// Blank lines don't get indented
+	m = typeKeys(m, ">")
+	is.Equal(text(), "func f() {\n\tif x {\n\n\t  a()\n\t}\n}\n")
+	m = typeKeys(m, "<<")
+	is.Equal(text(), "func f() {\nif x {\n\na()\n}\n}\n")
+
+	// = indents from the tree
+	m.source.setSelection(selection{anchor: 0, end: m.source.data.Len() - 1})
+	m = typeKeys(m, "=")
+	is.Equal(text(), "func f() {\n\tif x {\n\n\t\ta()\n\t}\n}\n")
+	m = typeKeys(m, "u")
+	is.Equal(text(), "func f() {\nif x {\n\na()\n}\n}\n")
+
+	// Spaces are removed up to the width of the indentation unit
+	m = newTestModel(t, "main.rs", []byte("      x\n  y\n"))
+	m.source.setSelection(selection{anchor: 0, end: m.source.data.Len() - 1})
+	m = typeKeys(m, "<")
+	is.Equal(text(), "  x\ny\n")
+	m = typeKeys(m, ">")
+	is.Equal(text(), "      x\n    y\n")
+
+	// Ranges line up on the column of the last one
+	content = "a = 1\nbcd = 2\n\tx = 3\n"
+	m = newTestModel(t, "notes.txt", []byte(content))
+	var ranges []textRange
+	for _, s := range []string{"= 1", "= 2", "= 3"} {
+		i := strings.Index(content, s)
+		ranges = append(ranges, textRange{i, i + 1})
+	}
+	m.source.Align(ranges)
+	is.Equal(text(), "a     = 1\nbcd   = 2\n\tx = 3\n")
+}
+
+func TestTransform(t *testing.T) {
+	is := is.New(t)
+
+	content := "Hello Wörld\nok"
+	m := newTestModel(t, "notes.txt", []byte(content))
+	text := func() string { return string(m.source.data.Bytes()) }
+	m.source.setSelection(selection{anchor: 0, end: strings.Index(content, "d\n")})
+
+	m = typeKeys(m, "~")
+	is.Equal(text(), "hELLO wÖRLD\nok")
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'`'}, Alt: true})
+	is.Equal(text(), "HELLO WÖRLD\nok")
+	m = typeKeys(m, "`")
+	is.Equal(text(), "hello wörld\nok")
+	is.Equal(selected(m), "hello wörld")
+
+	// Line breaks aren't replaced
+	m.source.setSelection(selection{anchor: strings.Index(content, "d"), end: m.source.data.Len() - 1})
+	m = typeKeys(m, "r-")
+	is.Equal(text(), "hello wörl-\n--")
+	m = typeKeys(m, "u")
+	is.Equal(text(), "hello wörld\nok")
+
+	for _, tc := range []struct {
+		line   string
+		cursor int
+		n      int
+		want   string
+	}{
+		{"x = 9;", 0, 1, "x = 10;"},
+		{"x = 10;", 5, -1, "x = 9;"},
+		{"x = -1;", 0, 2, "x = 1;"},
+		{"x = 0;", 4, -1, "x = -1;"},
+		{"x-1", 0, 1, "x-2"},
+		{"f(-1)", 0, 1, "f(0)"},
+		{"x = 007", 4, 1, "x = 008"},
+		{"0xfF", 0, 1, "0x100"},
+		{"0x0F", 0, 1, "0x10"},
+		{"0b0111", 0, 1, "0b1000"},
+		{"0o17", 0, -1, "0o16"},
+		{"0x0", 0, -1, "0x0"},
+		{"a1 b2", 2, 1, "a1 b2"},
+		{"x 1 2", 3, 1, "x 1 3"},
+		{"ok = true", 0, 1, "ok = false"},
+		{"True", 0, -1, "False"},
+		{"on 2024-01-31", 0, 1, "on 2024-02-01"},
+		{"2024-01-31", 5, 1, "2024-02-29"},
+		{"2024-02-29", 0, 1, "2025-02-28"},
+		{"2024-03-01 1", 8, -1, "2024-02-29 1"},
+		{"2024-03-01 1", 11, 1, "2024-03-01 2"},
+	} {
+		m = newTestModel(t, "notes.txt", []byte(tc.line))
+		m.source.SetCursor(tc.cursor)
+		m.source.Increment(tc.n)
+		is.Equal(text(), tc.want) // tc.line
+	}
+
+	// The number gets selected, and ctrl-x is undone at once
+	m = newTestModel(t, "notes.txt", []byte("x = 10"))
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyCtrlX})
+	is.Equal(selected(m), "9")
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyCtrlA})
+	is.Equal(selected(m), "10")
+	m = typeKeys(m, "uu")
+	is.Equal(text(), "x = 10")
+
+	// Counts say how much to add
+	m = typeKeys(m, "5")
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyCtrlA})
+	is.Equal(text(), "x = 15")
+	m = typeKeys(m, "20")
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyCtrlX})
+	is.Equal(text(), "x = -5")
+}
+
+func TestRepeat(t *testing.T) {
+	is := is.New(t)
+
+	m := newTestModel(t, "notes.txt", []byte("one two\nthree\n"))
+	text := func() string { return string(m.source.data.Bytes()) }
+	esc := func() {
+		m, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	}
+
+	// Insert sessions are repeated with everything typed in them
+	m = typeKeys(m, "iab")
+	esc()
+	is.Equal(text(), "abone two\nthree\n")
+	m = typeKeys(m, "j.")
+	is.Equal(text(), "abone two\nabthree\n")
+
+	// Moving and undoing aren't changes, so "." still repeats the insert
+	m = typeKeys(m, "u")
+	is.Equal(text(), "abone two\nthree\n")
+	m = typeKeys(m, "k.")
+	is.Equal(text(), "ababone two\nthree\n")
+	// and is undone at once
+	m = typeKeys(m, "u")
+	is.Equal(text(), "abone two\nthree\n")
+
+	// Key sequences are repeated as a whole
+	m.source.SetCursor(strings.Index(text(), "two"))
+	m = typeKeys(m, "ms\"")
+	is.Equal(text(), "abone \"t\"wo\nthree\n")
+	m.source.SetCursor(strings.Index(text(), "three"))
+	m = typeKeys(m, ".")
+	is.Equal(text(), "abone \"t\"wo\n\"t\"hree\n")
+	// The case switched twice is back where it was
+	m = typeKeys(m, "~.")
+	is.Equal(text(), "abone \"t\"wo\n\"t\"hree\n")
+	is.Equal(len(m.lastChange), 1)
+}
+
+func TestMacros(t *testing.T) {
+	is := is.New(t)
+
+	// The key notation reads back the keys it wrote
+	keys := []tea.KeyMsg{
+		{Type: tea.KeyRunes, Runes: []rune{'m'}},
+		{Type: tea.KeyRunes, Runes: []rune{'<'}},
+		{Type: tea.KeySpace, Runes: []rune{' '}},
+		{Type: tea.KeyEnter},
+		{Type: tea.KeyEsc},
+		{Type: tea.KeyTab},
+		{Type: tea.KeyCtrlA},
+		{Type: tea.KeyRunes, Runes: []rune{'o'}, Alt: true},
+		{Type: tea.KeyRunes, Runes: []rune{'`'}, Alt: true},
+		{Type: tea.KeyUp, Alt: true},
+	}
+	var notation string
+	for _, key := range keys {
+		notation += keyNotation(key)
+	}
+	is.Equal(notation, "m<lt><space><ret><esc><tab><C-a><A-o><A-`><A-up>")
+	parsed, err := parseKeys(notation)
+	is.NoErr(err)
+	is.Equal(parsed, keys)
+	_, err = parseKeys("<nope>")
+	is.True(err != nil)
+
+	// Macros of the config are checked when it's loaded
+	is.NoErr(CheckMacros(map[string]string{"a": "ms(<esc>", "b": "<C-a>"}))
+	err = CheckMacros(map[string]string{"a": "<nope>", "ab": "j", "c": "<esc"})
+	is.Equal(err.Error(), `macro a: unknown key <nope>; macro "ab": registers are single characters; macro c: unclosed key "<esc"`)
+
+	cfg := config.Default()
+	cfg.Macros = map[string]string{"a": "<C-a>j"}
+	m := newTestModel(t, "notes.txt", []byte("one\ntwo\nthree\nfour\n"))
+	m.config = cfg
+	text := func() string { return string(m.source.data.Bytes()) }
+
+	// Everything typed between the two Q is recorded, insert mode included
+	m = typeKeys(m, "Qi-")
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	m = typeKeys(m, "jQ")
+	is.Equal(text(), "-one\ntwo\nthree\nfour\n")
+	is.Equal(m.registers["@"], "i-<esc>j")
+
+	// and replayed with q, count times, as a single change
+	m = typeKeys(m, "q")
+	is.Equal(text(), "-one\n-two\nthree\nfour\n")
+	m = typeKeys(m, "2q")
+	is.Equal(text(), "-one\n-two\n-three\n-four\n")
+	m = typeKeys(m, "u")
+	is.Equal(text(), "-one\n-two\nthree\nfour\n")
+
+	// Registers are picked with ", and may come from the config
+	m.source.SetCursor(0)
+	m = typeKeys(m, "i1 ")
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	m.source.SetCursor(0)
+	m = typeKeys(m, "\"aq")
+	is.Equal(text(), "2 -one\n-two\nthree\nfour\n")
+	is.Equal(m.register, "")
+	is.Equal(m.count, 0)
+
+	// Text objects of replayed macros are found in the edited content
+	m = newTestModel(t, "main.go", []byte("package main\n\nfunc a() {}\nfunc b() {}\nfunc c() {}\n"))
+	m = typeKeys(m, "Q]fdQ")
+	is.Equal(text(), "package main\n\n\nfunc b() {}\nfunc c() {}\n")
+	m = typeKeys(m, "2q")
+	is.Equal(text(), "package main\n\n\n\n\n")
+}
+
+func TestModes(t *testing.T) {
+	is := is.New(t)
+
+	m := newTestModel(t, "main.go", []byte("package main\n\nfunc a() {}\n"))
+	text := func() string { return string(m.source.data.Bytes()) }
+
+	// Keys inside sequences don't switch modes
+	m = typeKeys(m, "mifri")
+	is.Equal(m.Mode, Normal)
+	m = typeKeys(m, "r:")
+	is.Equal(m.Mode, Normal)
+
+	m = typeKeys(m, "v")
+	is.Equal(m.Mode, Select)
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	is.Equal(m.Mode, Normal)
+
+	// Keys typed on the command line never reach the text
+	before := text()
+	m = typeKeys(m, ":write")
+	is.Equal(m.Mode, Command)
+	is.Equal(text(), before)
+}
@@ -1 +1,365 @@
 package buffer
+
+import (
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// command is something done in normal mode
+type command struct {
+	run func(m *Model)
+	// Whether it changes the text, so that "." repeats it. Commands
+	// entering insert mode are repeated along with what gets typed.
+	change bool
+	// Whether it only prepares the next command, like counts and registers
+	prefix bool
+}
+
+// onSource runs f on the source of the model
+func onSource(f func(*SourceCode)) func(*Model) {
+	return func(m *Model) { f(m.source) }
+}
+
+// commands are the normal mode commands, by name
+var commands = map[string]command{
+	"page_cursor_half_up":   {run: func(m *Model) { m.scroll(-m.viewport.height / 2) }},
+	"page_cursor_half_down": {run: func(m *Model) { m.scroll(m.viewport.height / 2) }},
+	"move_line_down":        {run: func(m *Model) { m.source.cursorDown(1) }},
+	"move_line_up":          {run: func(m *Model) { m.source.cursorUp(1) }},
+	"move_char_right":       {run: func(m *Model) { m.source.cursorRight(1) }},
+	"move_char_left":        {run: func(m *Model) { m.source.cursorLeft(1) }},
+
+	"insert_mode": {run: func(m *Model) { m.Mode = Insert }, change: true},
+	"select_mode": {run: func(m *Model) {
+		if m.Mode == Select {
+			m.Mode = Normal
+		} else {
+			m.Mode = Select
+		}
+	}},
+	"command_mode": {run: func(m *Model) { m.Mode = Command }},
+	"delete_selection": {run: func(m *Model) {
+		start, end := m.source.GetSelection()
+		m.source.DeleteRange(start, end+1)
+	}, change: true},
+	"toggle_comments":     {run: onSource((*SourceCode).ToggleComments), change: true},
+	"indent":              {run: onSource((*SourceCode).IndentLines), change: true},
+	"unindent":            {run: onSource((*SourceCode).DedentLines), change: true},
+	"reindent":            {run: onSource((*SourceCode).ReindentLines), change: true},
+	"switch_case":         {run: onSource((*SourceCode).SwitchCase), change: true},
+	"switch_to_lowercase": {run: onSource((*SourceCode).Lowercase), change: true},
+	"switch_to_uppercase": {run: onSource((*SourceCode).Uppercase), change: true},
+	"increment":           {run: func(m *Model) { m.source.Increment(max(m.count, 1)) }, change: true},
+	"decrement":           {run: func(m *Model) { m.source.Increment(-max(m.count, 1)) }, change: true},
+
+	"undo": {run: onSource((*SourceCode).Undo)},
+	"redo": {run: onSource((*SourceCode).Redo)},
+
+	// Syntax aware selection
+	"expand_selection":    {run: onSource((*SourceCode).ExpandSelection)},
+	"shrink_selection":    {run: onSource((*SourceCode).ShrinkSelection)},
+	"select_next_sibling": {run: onSource((*SourceCode).SelectNextSibling)},
+	"select_prev_sibling": {run: onSource((*SourceCode).SelectPrevSibling)},
+}
+
+func init() {
+	// Added here, as it runs the other commands
+	commands["repeat_last_change"] = command{run: (*Model).repeatLastChange}
+	commands["record_macro"] = command{run: (*Model).toggleRecording}
+	commands["replay_macro"] = command{run: (*Model).replayMacro}
+}
+
+// normalKeys binds keys to the normal mode commands. Key sequences, like
+// "mif", are handled by keySequence.
+var normalKeys = map[string]string{
+	"ctrl+u": "page_cursor_half_up",
+	"ctrl+d": "page_cursor_half_down",
+	"j":      "move_line_down",
+	"down":   "move_line_down",
+	"k":      "move_line_up",
+	"up":     "move_line_up",
+	"l":      "move_char_right",
+	"right":  "move_char_right",
+	"h":      "move_char_left",
+	"left":   "move_char_left",
+
+	// TODO: w selects the current word
+	"i":      "insert_mode",
+	"v":      "select_mode",
+	":":      "command_mode",
+	"d":      "delete_selection",
+	"ctrl+c": "toggle_comments",
+	">":      "indent",
+	"<":      "unindent",
+	"=":      "reindent",
+	"~":      "switch_case",
+	"`":      "switch_to_lowercase",
+	"alt+`":  "switch_to_uppercase",
+	"ctrl+a": "increment",
+	"ctrl+x": "decrement",
+	"u":      "undo",
+	"U":      "redo",
+	".":      "repeat_last_change",
+	"Q":      "record_macro",
+	"q":      "replay_macro",
+
+	"alt+o":     "expand_selection",
+	"alt+up":    "expand_selection",
+	"alt+i":     "shrink_selection",
+	"alt+down":  "shrink_selection",
+	"alt+n":     "select_next_sibling",
+	"alt+right": "select_next_sibling",
+	"alt+p":     "select_prev_sibling",
+	"alt+left":  "select_prev_sibling",
+}
+
+// Text objects selected by "mi" and "ma", by their last key
+var matchObjects = map[string]string{
+	"f": "function",
+	"c": "class",
+	"a": "parameter",
+	"o": "comment",
+}
+
+// Text objects jumped to by "]" and "[", by their last key
+var gotoObjects = map[string]string{
+	"f": "function",
+	"t": "class",
+	"a": "parameter",
+	"c": "comment",
+}
+
+// Fold commands, by the key typed after "z"
+var foldCommands = map[string]func(*SourceCode){
+	"c": (*SourceCode).CloseFold,
+	"o": (*SourceCode).OpenFold,
+	"a": (*SourceCode).ToggleFold,
+	"R": (*SourceCode).OpenAllFolds,
+	"M": (*SourceCode).CloseAllFolds,
+}
+
+// handleKey does what a key does in the current mode. Typed keys and the
+// ones repeated by "." all go through here. Keys typed in command mode are
+// ignored, they belong to the command line.
+func (m *Model) handleKey(msg tea.KeyMsg) {
+	switch m.Mode {
+	case Normal:
+		m.normalKey(msg)
+	case Select:
+		// Commands are the same as in normal mode, for now
+		if msg.Type == tea.KeyEsc && m.pending == "" {
+			m.Mode = Normal
+			return
+		}
+		m.normalKey(msg)
+	case Insert:
+		m.typed = append(m.typed, msg)
+		if m.recording != "" && m.replaying == 0 {
+			m.macro = append(m.macro, msg)
+		}
+		m.insertKey(msg)
+		if m.Mode == Normal {
+			m.lastChange, m.typed = m.typed, nil
+		}
+	}
+}
+
+// normalKey runs the command of a key in normal mode, once its sequence is
+// complete
+func (m *Model) normalKey(msg tea.KeyMsg) {
+	m.typed = append(m.typed, msg)
+	cmd, ok := m.keySequence(msg.String())
+	if !ok {
+		cmd = commands[normalKeys[msg.String()]]
+	}
+	if cmd.prefix {
+		cmd.run(m)
+		return
+	}
+	if m.pending != "" {
+		return
+	}
+
+	keys := m.typed
+	m.typed = nil
+	recording := m.recording != ""
+	if cmd.run != nil {
+		cmd.run(m)
+	}
+	m.count, m.register = 0, ""
+	// Keys replayed are recorded by the key replaying them, and the keys
+	// starting or stopping the recording aren't recorded at all
+	if recording && m.recording != "" && m.replaying == 0 {
+		m.macro = append(m.macro, keys...)
+	}
+	if cmd.run == nil {
+		return
+	}
+	if cmd.change && m.Mode == Insert {
+		// The insert session gets recorded until it ends
+		m.typed = keys
+	} else if cmd.change {
+		m.lastChange = keys
+	}
+}
+
+// insertKey types a key in insert mode
+func (m *Model) insertKey(msg tea.KeyMsg) {
+	if msg.Alt {
+		return
+	}
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.Mode = Normal
+	case tea.KeyRunes:
+		if text := msg.String(); len(text) == 1 {
+			m.source.InsertChar(text[0])
+		} else {
+			m.source.Insert([]byte(text))
+		}
+	case tea.KeySpace:
+		m.source.Insert([]byte{' '})
+	case tea.KeyTab:
+		m.source.Insert([]byte{'\t'})
+	case tea.KeyEnter:
+		m.source.InsertNewline()
+	case tea.KeyBackspace:
+		m.source.Backspace()
+	case tea.KeyDelete:
+		m.source.Delete()
+	case tea.KeyRight:
+		m.source.cursor = min(m.source.cursor+1, m.source.data.Len())
+	case tea.KeyLeft:
+		m.source.cursor = max(m.source.cursor-1, 0)
+	}
+}
+
+// repeatLastChange types the keys of the last change again, at the current
+// selection
+func (m *Model) repeatLastChange() {
+	m.replay(slices.Clone(m.lastChange))
+}
+
+// replay handles keys as if they were typed, for "." and macros
+func (m *Model) replay(keys []tea.KeyMsg) {
+	m.replaying++
+	m.count, m.register, m.pending = 0, "", ""
+	for _, msg := range keys {
+		m.handleKey(msg)
+	}
+	m.replaying--
+
+	// Sequences left incomplete are dropped
+	m.pending = ""
+	if m.Mode != Insert {
+		m.typed = nil
+	}
+}
+
+// keySequence handles the keys of sequences like "mif", "ms(" or "]f", and
+// of the counts and registers typed before commands, like "3" or "\"a". The
+// keys typed so far are kept in m.pending until the sequence is complete,
+// which returns its command. Returns false if key doesn't belong to a
+// sequence.
+func (m *Model) keySequence(key string) (command, bool) {
+	pending := m.pending
+	m.pending = ""
+
+	switch {
+	case pending == "" && len(key) == 1 && '0' <= key[0] && key[0] <= '9' && (key != "0" || m.count > 0):
+		return command{run: func(m *Model) { m.count = 10*m.count + int(key[0]-'0') }, prefix: true}, true
+
+	case pending == "\"" && isCharKey(key):
+		return command{run: func(m *Model) { m.register = key }, prefix: true}, true
+
+	case pending == "" && (key == "\"" || key == "m" || key == "]" || key == "[" || key == "z" || key == "r"),
+		pending == "m" && (key == "i" || key == "a" || key == "s" || key == "r" || key == "d"),
+		pending == "mr" && isCharKey(key):
+		m.pending = pending + key
+
+	case pending == "m" && key == "m":
+		return command{run: func(m *Model) {
+			m.source.MatchBracket()
+			m.scrollToCursor()
+		}}, true
+
+	case pending == "mi" || pending == "ma":
+		if object, ok := matchObjects[key]; ok {
+			return command{run: func(m *Model) { m.source.SelectTextObject(object, pending == "ma") }}, true
+		}
+
+	case pending == "ms" && isCharKey(key):
+		return command{run: func(m *Model) { m.source.Surround(key) }, change: true}, true
+
+	case pending == "md" && isCharKey(key):
+		return command{run: func(m *Model) { m.source.DeleteSurround(key) }, change: true}, true
+
+	case strings.HasPrefix(pending, "mr") && isCharKey(key):
+		return command{run: func(m *Model) { m.source.ReplaceSurround(pending[len("mr"):], key) }, change: true}, true
+
+	case pending == "r":
+		if key == "enter" {
+			key = "\n"
+		} else if key == "tab" {
+			key = "\t"
+		}
+		if isCharKey(key) {
+			return command{run: func(m *Model) { m.source.ReplaceChars([]rune(key)[0]) }, change: true}, true
+		}
+
+	case pending == "]" || pending == "[":
+		if object, ok := gotoObjects[key]; ok {
+			return command{run: func(m *Model) {
+				m.source.GotoTextObject(object, pending == "]")
+				m.scrollToCursor()
+			}}, true
+		}
+
+	case pending == "z":
+		if fold, ok := foldCommands[key]; ok {
+			return command{run: func(m *Model) {
+				fold(m.source)
+				// The first line shown may have been folded away
+				m.viewport.offset = m.source.closedFolds().visible(m.viewport.offset)
+			}}, true
+		}
+
+	case pending != "":
+		// Not a known sequence, the keys are dropped
+
+	default:
+		return command{}, false
+	}
+	return command{}, true
+}
+
+// isCharKey reports whether key types a single character, like the pairs of
+// "ms(" or the replacement of "rx"
+func isCharKey(key string) bool {
+	return utf8.RuneCountInString(key) == 1
+}
+
+// scroll moves the viewport by n visible lines, down if n is positive
+func (m *Model) scroll(n int) {
+	offset := m.source.moveVisible(m.viewport.offset, n)
+	// Scrolling down stops once the last line is near the bottom. Looking
+	// for the last line only when it's close saves reading all of a large
+	// file.
+	if _, left := m.source.stepVisible(offset, m.viewport.height-2); n > 0 && left > 0 {
+		last := max(0, m.source.moveVisible(m.source.LineCount()-1, -(m.viewport.height-2)))
+		offset = max(last, m.viewport.offset)
+	}
+	m.viewport.offset = offset
+}
+
+// scrollToCursor scrolls the cursor into the middle of the viewport, unless
+// it's visible already
+func (m *Model) scrollToCursor() {
+	line, _, _ := m.source.CurrentLine()
+	if line < m.viewport.offset || line >= m.viewport.offset+m.viewport.height {
+		m.viewport.offset = max(0, line-m.viewport.height/2)
+	}
+}
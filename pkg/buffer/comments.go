@@ -0,0 +1,90 @@
+package buffer
+
+import (
+	"strings"
+)
+
+// Comment toggling. The tokens come from the language, see
+// syntax.Language.CommentToken.
+
+// ToggleComments comments the selected lines out, or uncomments them if
+// they are all comments already. Languages without line comments get a
+// block comment around the lines instead.
+func (s *SourceCode) ToggleComments() {
+	switch {
+	case s.comment != "":
+		s.toggleLineComments(s.comment)
+	case s.blockComment.Start != "" && s.blockComment.End != "":
+		s.toggleBlockComment(s.blockComment.Start, s.blockComment.End)
+	}
+}
+
+// toggleLineComments toggles the line comments of the selected lines. Blank
+// lines are left alone. Comments are added at the smallest indentation of
+// the lines, so they line up.
+func (s *SourceCode) toggleLineComments(token string) {
+	first, last := s.selectedLines()
+	commented := true
+	column := -1
+	for i := first; i <= last; i++ {
+		line := s.Line(i)
+		indent := len(s.indentation(line))
+		if line.start+indent == line.end {
+			continue
+		}
+		commented = commented && s.hasAt(line.start+indent, token)
+		if column < 0 || indent < column {
+			column = indent
+		}
+	}
+	if column < 0 {
+		return
+	}
+
+	var edits []edit
+	for i := first; i <= last; i++ {
+		line := s.Line(i)
+		pos := line.start + len(s.indentation(line))
+		switch {
+		case pos == line.end:
+		case commented:
+			del := len(token)
+			if pos+del < line.end && s.data.ByteAt(pos+del) == ' ' {
+				del++
+			}
+			edits = append(edits, edit{pos: pos, del: del})
+		default:
+			edits = append(edits, edit{pos: line.start + column, text: token + " "})
+		}
+	}
+	s.applyEdits(edits)
+}
+
+// toggleBlockComment puts the selected lines inside a block comment, or
+// removes the one around them
+func (s *SourceCode) toggleBlockComment(open, close string) {
+	first, last := s.selectedLines()
+	start := s.Line(first).start + len(s.indentation(s.Line(first)))
+	end := s.Line(last).end
+	for end > start && isBlank(s.data.ByteAt(end-1)) {
+		end--
+	}
+	if start == end {
+		return
+	}
+
+	text := string(s.data.Slice(start, end))
+	if strings.HasPrefix(text, open) && strings.HasSuffix(text, close) && len(text) >= len(open)+len(close) {
+		del := len(open)
+		if strings.HasPrefix(text[del:], " ") {
+			del++
+		}
+		delEnd := len(close)
+		if strings.HasSuffix(text[:len(text)-delEnd], " ") && len(text)-delEnd-1 >= del {
+			delEnd++
+		}
+		s.applyEdits([]edit{{pos: start, del: del}, {pos: end - delEnd, del: delEnd}})
+		return
+	}
+	s.applyEdits([]edit{{pos: start, text: open + " "}, {pos: end, text: " " + close}})
+}
@@ -0,0 +1,77 @@
+package buffer
+
+import (
+	"slices"
+	"strings"
+)
+
+// Edits made at several places at once, like commenting every selected line
+
+// edit replaces the del characters at pos by text
+type edit struct {
+	pos, del int
+	text     string
+}
+
+// selectedLines returns the first and last lines touched by the selection
+func (s *SourceCode) selectedLines() (int, int) {
+	start, end := s.GetSelection()
+	return s.lineOf(start), s.lineOf(min(end, s.data.Len()))
+}
+
+// applyEdits applies edits, which are sorted by position and don't overlap.
+// The selection moves along with the text around it.
+func (s *SourceCode) applyEdits(edits []edit) {
+	move := func(pos int) int {
+		shift := 0
+		for _, e := range edits {
+			// pos stays in front of text inserted right there
+			if pos < e.pos || pos == e.pos && e.del == 0 {
+				break
+			}
+			if pos < e.pos+e.del {
+				return e.pos + shift
+			}
+			shift += len(e.text) - e.del
+		}
+		return pos + shift
+	}
+	sel := s.selection()
+
+	for i := len(edits) - 1; i >= 0; i-- {
+		e := edits[i]
+		if e.del > 0 {
+			s.deleteAt(e.pos, e.pos+e.del)
+		}
+		if e.text != "" {
+			s.insertAt(e.pos, []byte(e.text))
+		}
+	}
+	s.setSelection(selection{anchor: move(sel.anchor), end: move(sel.end), cursor: move(sel.cursor)})
+}
+
+// Align lines up the starts of ranges, which are all on different lines,
+// by inserting spaces in front of them. Ranges sharing a line are left
+// alone. Not bound to "&" yet, as there is a single selection for now.
+func (s *SourceCode) Align(ranges []textRange) {
+	lines := map[int]bool{}
+	column := 0
+	for _, r := range ranges {
+		line := s.Line(s.lineOf(r.start))
+		if lines[line.start] {
+			return
+		}
+		lines[line.start] = true
+		column = max(column, s.LineWidth(Line{line.start, r.start}))
+	}
+
+	var edits []edit
+	for _, r := range ranges {
+		line := s.Line(s.lineOf(r.start))
+		if width := s.LineWidth(Line{line.start, r.start}); width < column {
+			edits = append(edits, edit{pos: r.start, text: strings.Repeat(" ", column-width)})
+		}
+	}
+	slices.SortFunc(edits, func(a, b edit) int { return a.pos - b.pos })
+	s.applyEdits(edits)
+}
@@ -0,0 +1,228 @@
+package buffer
+
+import (
+	"slices"
+)
+
+// Folding hides the lines of a function, block or comment behind the first
+// one. What can be folded comes from the folds.scm query of the language,
+// or from the indentation if there is none.
+
+// lineRange is a range of lines, both ends included
+type lineRange struct {
+	start, end int
+}
+
+// folding holds the closed folds. The first line of a fold stays visible,
+// the others are hidden.
+type folding []lineRange
+
+// closedFolds returns the closed folds, as lines
+func (s *SourceCode) closedFolds() folding {
+	folds := make(folding, 0, len(s.folds))
+	for _, r := range s.folds {
+		if f := (lineRange{s.lineOf(r.start), s.lineOf(r.end)}); f.end > f.start {
+			folds = append(folds, f)
+		}
+	}
+	return folds
+}
+
+// end returns the last line shown as part of line: the end of the fold
+// starting there, or line itself
+func (f folding) end(line int) int {
+	end := line
+	for _, r := range f {
+		if r.start == line {
+			end = max(end, r.end)
+		}
+	}
+	return end
+}
+
+// visible returns the line shown in place of line: the first line of the
+// outermost fold hiding it, or line itself
+func (f folding) visible(line int) int {
+	visible := line
+	for _, r := range f {
+		if r.start < line && line <= r.end {
+			visible = min(visible, r.start)
+		}
+	}
+	return visible
+}
+
+// moveVisible returns the line n visible lines after line, or before it if
+// n is negative. Stops at the first and last lines.
+func (s *SourceCode) moveVisible(line, n int) int {
+	line, _ = s.stepVisible(line, n)
+	return line
+}
+
+// stepVisible is moveVisible, which also returns how many of the n lines it
+// couldn't move, because it reached the first or last line
+func (s *SourceCode) stepVisible(line, n int) (int, int) {
+	folds := s.closedFolds()
+	line = folds.visible(s.clampLine(line))
+	for ; n > 0; n-- {
+		next := folds.end(line) + 1
+		if !s.hasLine(next) {
+			break
+		}
+		line = next
+	}
+	for ; n < 0 && line > 0; n++ {
+		line = folds.visible(line - 1)
+	}
+	return line, n
+}
+
+// foldRanges returns every range of lines which can be folded, from the
+// folds query or else from the indentation. Large files have none, as
+// finding them would mean reading the whole file.
+func (s *SourceCode) foldRanges() []lineRange {
+	if s.large {
+		return nil
+	}
+	query := s.queries.folds
+	root := s.freshTree()
+	if query == nil || root == nil {
+		return s.indentFolds()
+	}
+
+	var ranges []lineRange
+	for _, r := range s.captureRanges(root, query, "fold") {
+		end := r.end
+		// Nodes ending with a line break end on the line before
+		if end > r.start && s.data.ByteAt(end-1) == '\n' {
+			end--
+		}
+		if f := (lineRange{s.lineOf(r.start), s.lineOf(end)}); f.end > f.start && !slices.Contains(ranges, f) {
+			ranges = append(ranges, f)
+		}
+	}
+	return ranges
+}
+
+// indentFolds returns the ranges of lines followed by more indented ones.
+// Blank lines belong to the indented block around them.
+func (s *SourceCode) indentFolds() []lineRange {
+	type header struct{ line, width int }
+	var ranges []lineRange
+	var stack []header // Lines whose more indented lines are still going on
+	last := -1         // Last line which isn't blank
+	close := func(width int) {
+		for len(stack) > 0 && stack[len(stack)-1].width >= width {
+			start := stack[len(stack)-1].line
+			stack = stack[:len(stack)-1]
+			if last > start {
+				ranges = append(ranges, lineRange{start, last})
+			}
+		}
+	}
+
+	for i := 0; i < s.LineCount(); i++ {
+		line := s.Line(i)
+		indent := s.indentation(line)
+		if line.start+len(indent) == line.end {
+			continue
+		}
+		width := 0
+		for _, c := range []byte(indent) {
+			if c == '\t' {
+				width += 4
+			} else {
+				width++
+			}
+		}
+		close(width)
+		stack = append(stack, header{i, width})
+		last = i
+	}
+	close(-1)
+	return ranges
+}
+
+// foldAround returns the smallest fold around line which isn't closed yet
+func (s *SourceCode) foldAround(line int) (lineRange, bool) {
+	closed := s.closedFolds()
+	var best lineRange
+	found := false
+	for _, r := range s.foldRanges() {
+		if r.start <= line && line <= r.end && !slices.Contains(closed, r) &&
+			(!found || r.end-r.start < best.end-best.start) {
+			best, found = r, true
+		}
+	}
+	return best, found
+}
+
+// closeFold hides the lines of r, and moves the cursor out of them
+func (s *SourceCode) closeFold(r lineRange) {
+	s.folds = append(s.folds, textRange{s.Line(r.start).start, s.Line(r.end).end})
+	if line := s.lineOf(s.cursor); r.start < line && line <= r.end {
+		s.SetCursor(s.Line(r.start).start)
+		s.RelalcHpos()
+	}
+}
+
+// CloseFold folds the code around the cursor. If the cursor is on a closed
+// fold already, the one around it gets closed.
+func (s *SourceCode) CloseFold() {
+	if r, ok := s.foldAround(s.lineOf(s.cursor)); ok {
+		s.closeFold(r)
+	}
+}
+
+// OpenFold opens the outermost closed fold on the line of the cursor
+func (s *SourceCode) OpenFold() {
+	line := s.lineOf(s.cursor)
+	outer := -1
+	for i, r := range s.folds {
+		f := lineRange{s.lineOf(r.start), s.lineOf(r.end)}
+		if f.start == line && (outer < 0 || s.folds[outer].end < r.end) {
+			outer = i
+		}
+	}
+	if outer >= 0 {
+		s.folds = slices.Delete(s.folds, outer, outer+1)
+	}
+}
+
+// ToggleFold opens the fold on the line of the cursor if there is one, and
+// closes the one around it otherwise
+func (s *SourceCode) ToggleFold() {
+	line := s.lineOf(s.cursor)
+	if s.closedFolds().end(line) > line {
+		s.OpenFold()
+	} else {
+		s.CloseFold()
+	}
+}
+
+// OpenAllFolds shows every line again
+func (s *SourceCode) OpenAllFolds() {
+	s.folds = nil
+}
+
+// CloseAllFolds closes every fold there is
+func (s *SourceCode) CloseAllFolds() {
+	s.folds = nil
+	for _, r := range s.foldRanges() {
+		s.closeFold(r)
+	}
+}
+
+// shiftFolds keeps the folds on their lines when the text of [start, end)
+// gets replaced by n characters. Folds touched by the edit get opened.
+func (s *SourceCode) shiftFolds(start, end, n int) {
+	s.folds = slices.DeleteFunc(s.folds, func(r textRange) bool {
+		return start <= r.end && end >= r.start
+	})
+	for i := range s.folds {
+		if s.folds[i].start >= end {
+			s.folds[i].start += n - (end - start)
+			s.folds[i].end += n - (end - start)
+		}
+	}
+}
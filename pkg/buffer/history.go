@@ -0,0 +1,85 @@
+package buffer
+
+// Undo history. Every edit goes through insertAt and deleteAt, which record
+// it as a change. The changes made by a single command, or during one visit
+// to insert mode, are committed together as a revision, and undone together.
+
+// change replaced the text deleted at pos by the text inserted
+type change struct {
+	pos      int
+	deleted  []byte
+	inserted []byte
+}
+
+// revision is a group of changes, undone and redone as one
+type revision struct {
+	changes []change
+	// Selection before and after the changes
+	before, after selection
+}
+
+// history holds the revisions which can be undone and redone, along with
+// the changes which aren't committed yet
+type history struct {
+	undo, redo []revision
+	changes    []change
+	before     selection // Selection before the first uncommitted change
+}
+
+// record adds an edit to the uncommitted changes
+func (s *SourceCode) record(c change) {
+	if len(s.edits.changes) == 0 {
+		s.edits.before = s.selection()
+	}
+	s.edits.changes = append(s.edits.changes, c)
+}
+
+// Commit makes the changes since the last commit a single revision. Undone
+// revisions can't be redone anymore after that.
+func (s *SourceCode) Commit() {
+	if len(s.edits.changes) == 0 {
+		return
+	}
+	s.edits.undo = append(s.edits.undo, revision{
+		changes: s.edits.changes,
+		before:  s.edits.before,
+		after:   s.selection(),
+	})
+	s.edits.redo = nil
+	s.edits.changes = nil
+}
+
+// Undo reverts the last revision
+func (s *SourceCode) Undo() {
+	s.Commit()
+	s.revert(&s.edits.undo, &s.edits.redo)
+}
+
+// Redo applies the last undone revision again
+func (s *SourceCode) Redo() {
+	s.Commit()
+	s.revert(&s.edits.redo, &s.edits.undo)
+}
+
+// revert reverts the last revision of from, and adds the revision doing so
+// to to
+func (s *SourceCode) revert(from, to *[]revision) {
+	if len(*from) == 0 {
+		return
+	}
+	r := (*from)[len(*from)-1]
+	*from = (*from)[:len(*from)-1]
+
+	for i := len(r.changes) - 1; i >= 0; i-- {
+		c := r.changes[i]
+		if len(c.inserted) > 0 {
+			s.deleteAt(c.pos, c.pos+len(c.inserted))
+		}
+		if len(c.deleted) > 0 {
+			s.insertAt(c.pos, c.deleted)
+		}
+	}
+	*to = append(*to, revision{changes: s.edits.changes, before: r.after, after: r.before})
+	s.edits.changes = nil
+	s.setSelection(r.before)
+}
@@ -0,0 +1,393 @@
+package buffer
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// Automatic indentation. New lines are indented as the indents.scm query of
+// the language says, or like the line before them if there is none.
+
+// isBlank reports whether c is indentation
+func isBlank(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+// indentation returns the leading whitespace of a line
+func (s *SourceCode) indentation(l Line) string {
+	end := l.start
+	for end < l.end && isBlank(s.data.ByteAt(end)) {
+		end++
+	}
+	return string(s.data.Slice(l.start, end))
+}
+
+// indentUnit returns the text of one level of indentation
+func (s *SourceCode) indentUnit() string {
+	if s.indent == "" {
+		return "\t"
+	}
+	return s.indent
+}
+
+// freshTree returns the syntax tree of the current content. If the tree is
+// outdated, it gets parsed again right away instead of waiting for the
+// background parse. As the tree got the edits made since, only the parts
+// they touched are parsed. Until the first parse is delivered there is no
+// tree at all, as parsing the whole content would hold up typing.
+func (s *SourceCode) freshTree() *sitter.Node {
+	if root := s.syntaxTree(); root != nil || s.tree == nil {
+		return root
+	}
+	tree, ok := parseTree(context.Background(), s.data.Snapshot(), s.lang, s.tree)
+	if !ok {
+		return nil
+	}
+	s.tree, s.parsed = tree, s.version
+	return tree.RootNode()
+}
+
+// descendantAt returns the smallest node, named or not, containing pos
+func descendantAt(root *sitter.Node, pos int) *sitter.Node {
+	node := root
+	for {
+		var next *sitter.Node
+		for i := 0; i < int(node.ChildCount()); i++ {
+			child := node.Child(i)
+			if int(child.StartByte()) <= pos && pos < int(child.EndByte()) {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			return node
+		}
+		node = next
+	}
+}
+
+// treeIndent computes the indentation of a line from the indents query:
+//
+//   - @indent nodes add a level to the lines after the one they start on,
+//     but several of them starting on the same line add only one.
+//   - @extend nodes are like @indent ones, which also indent the line
+//     right after them, like the body of an "else:".
+//   - A line starting with an @outdent node gets one level less.
+//
+// Also reports whether the line starts with an @outdent node. Returns false
+// if the language has no indents query, or the previous line can't be parsed.
+func (s *SourceCode) treeIndent(row int) (indent string, outdent, ok bool) {
+	query := s.queries.indents
+	if query == nil {
+		return "", false, false
+	}
+	root := s.freshTree()
+	if root == nil {
+		return "", false, false
+	}
+
+	line := s.Line(row)
+	pos := line.start + len(s.indentation(line))
+	// End of the text before the line
+	prevEnd := line.start
+	for prevEnd > 0 && isSpace(s.data.ByteAt(prevEnd-1)) {
+		prevEnd--
+	}
+	if prevEnd > 0 {
+		for n := descendantAt(root, prevEnd-1); n != nil; n = n.Parent() {
+			if n.IsError() {
+				return "", false, false
+			}
+		}
+	}
+
+	qc := sitter.NewQueryCursor()
+	defer qc.Close()
+	qc.SetPointRange(sitter.Point{Row: uint32(max(row-1, 0))}, sitter.Point{Row: uint32(row + 1)})
+	qc.Exec(query, root)
+	rows := map[uint32]bool{}
+	for {
+		m, ok := qc.NextMatch()
+		if !ok {
+			break
+		}
+		for _, c := range m.Captures {
+			n := c.Node
+			start, end := int(n.StartByte()), int(n.EndByte())
+			// Unfinished nodes, like a block without its closing bracket
+			// yet, go on until the line
+			inside := int(n.StartPoint().Row) < row && (pos < end || end >= prevEnd && unfinished(n))
+			switch query.CaptureNameForId(c.Index) {
+			case "indent":
+				if inside {
+					rows[n.StartPoint().Row] = true
+				}
+			case "extend":
+				if inside || int(n.StartPoint().Row) < row && end == prevEnd {
+					rows[n.StartPoint().Row] = true
+				}
+			case "outdent":
+				outdent = outdent || start == pos && !n.IsMissing()
+			}
+		}
+	}
+
+	levels := len(rows)
+	if outdent && levels > 0 {
+		levels--
+	}
+	return strings.Repeat(s.indentUnit(), levels), outdent, true
+}
+
+// unfinished reports whether the last token of n is missing. The parser
+// inserts these to recover from incomplete code.
+func unfinished(n *sitter.Node) bool {
+	for n.ChildCount() > 0 {
+		n = n.Child(int(n.ChildCount()) - 1)
+	}
+	return n.IsMissing()
+}
+
+// isSpace reports whether c is whitespace, line breaks included
+func isSpace(c byte) bool {
+	return isBlank(c) || c == '\n' || c == '\r'
+}
+
+// InsertNewline breaks the line at the cursor and indents the new line.
+// Breaking an empty pair of brackets puts the closing one on a line of its
+// own.
+func (s *SourceCode) InsertNewline() {
+	row, line, _ := s.CurrentLine()
+	previous := s.indentation(line)
+	// Breaking the line inside its indentation keeps only what's before
+	previous = previous[:min(len(previous), s.cursor-line.start)]
+	brackets := s.emptyPair() && closingBrackets[s.data.ByteAt(s.cursor)] != 0
+
+	s.Insert([]byte{'\n'})
+	if brackets {
+		s.insertAt(s.cursor, []byte{'\n'})
+	}
+	indent, _, ok := s.treeIndent(row + 1)
+	if !ok {
+		indent = previous
+		// The line opens a block
+		if end := s.cursor - 1; end > line.start && strings.IndexByte("{([:", s.data.ByteAt(end-1)) >= 0 {
+			indent += s.indentUnit()
+		}
+	}
+	s.Insert([]byte(indent))
+
+	if brackets {
+		closing, _, ok := s.treeIndent(row + 2)
+		if !ok {
+			closing = previous
+		}
+		s.insertAt(s.Line(row+2).start, []byte(closing))
+	}
+}
+
+// closingBrackets are the brackets which dedent their line when typed
+var closingBrackets = map[byte]byte{'}': '{', ')': '(', ']': '['}
+
+// InsertClosing inserts a closing bracket. If it is the first character of
+// its line, the line gets the indentation of the line with the opening
+// bracket.
+func (s *SourceCode) InsertClosing(bracket byte) {
+	s.Insert([]byte{bracket})
+	row, line, _ := s.CurrentLine()
+	current := s.indentation(line)
+	if line.start+len(current) != s.cursor-1 {
+		return
+	}
+
+	indent, _, ok := s.treeIndent(row)
+	if !ok {
+		open := s.findOpening(s.cursor-1, closingBrackets[bracket], bracket, 0)
+		if open < 0 {
+			return
+		}
+		indent = s.indentation(s.Line(s.lineOf(open)))
+	}
+	s.reindent(line, current, indent)
+}
+
+// InsertWordChar types c, a character of a word. While typing the first word
+// of a line, the line gets indented again once the word becomes an @outdent
+// keyword, like the "case" of a switch, or stops being one. Only then is the
+// syntax tree needed.
+func (s *SourceCode) InsertWordChar(c byte) {
+	row, line, _ := s.CurrentLine()
+	current := s.indentation(line)
+	if !s.typingFirstWord(line, len(current)) {
+		s.Insert([]byte{c})
+		return
+	}
+
+	start := line.start + len(current)
+	before := s.queries.outdents[string(s.data.Slice(start, s.cursor))]
+	s.Insert([]byte{c})
+	after := s.queries.outdents[string(s.data.Slice(start, s.cursor))]
+	if before == after {
+		return
+	}
+	// The tree knows whether the keyword dedents here, like a case which
+	// is inside a switch
+	if indent, outdent, ok := s.treeIndent(row); ok && outdent == after {
+		s.reindent(line, current, indent)
+	}
+}
+
+// queryToken matches the parts of a query outdentKeywords looks at:
+// comments, nodes like "case", the brackets of lists and nodes, and captures
+var queryToken = regexp.MustCompile(`;[^\n]*|"(?:[^"\\]|\\.)*"|[\[\]()]|@[\w.]+`)
+
+// keywordNode matches the nodes of a query which are keywords
+var keywordNode = regexp.MustCompile(`^"\w+"$`)
+
+// outdentKeywords returns the keywords captured as @outdent by the given
+// indents query, on their own or in a list
+func outdentKeywords(query []byte) map[string]bool {
+	keywords := map[string]bool{}
+	var lists [][]string // Keywords of the lists being read
+	var last []string    // Keywords of what was read last, a node or a list
+	for _, token := range queryToken.FindAll(query, -1) {
+		switch t := string(token); {
+		case t[0] == '"':
+			last = nil
+			if keywordNode.MatchString(t) {
+				last = []string{t[1 : len(t)-1]}
+			}
+			if len(lists) > 0 {
+				lists[len(lists)-1] = append(lists[len(lists)-1], last...)
+			}
+		case t == "[":
+			lists = append(lists, nil)
+		case t == "]" && len(lists) > 0:
+			last = lists[len(lists)-1]
+			lists = lists[:len(lists)-1]
+		case t == "(" || t == ")":
+			last = nil
+		case t == "@outdent":
+			for _, k := range last {
+				keywords[k] = true
+			}
+		}
+	}
+	return keywords
+}
+
+// typingFirstWord reports whether the cursor is at the end of the first word
+// of line, whose indentation is indent bytes long
+func (s *SourceCode) typingFirstWord(line Line, indent int) bool {
+	if s.cursor < line.end && isWordByte(s.data.ByteAt(s.cursor)) {
+		return false
+	}
+	for i := line.start + indent; i < s.cursor; i++ {
+		if !isWordByte(s.data.ByteAt(i)) {
+			return false
+		}
+	}
+	return true
+}
+
+// reindent replaces the indentation of line, which is current, with indent.
+// The cursor, which is after the indentation, stays on its character.
+func (s *SourceCode) reindent(line Line, current, indent string) {
+	if indent == current {
+		return
+	}
+	s.deleteAt(line.start, line.start+len(current))
+	s.insertAt(line.start, []byte(indent))
+	s.cursor += len(indent) - len(current)
+}
+
+// findOpening scans backwards from the closing bracket at pos for the
+// bracket opening it, until start. Returns -1 if there is none.
+func (s *SourceCode) findOpening(pos int, open, close byte, start int) int {
+	depth := 0
+	for i := pos - 1; i >= start; i-- {
+		switch s.data.ByteAt(i) {
+		case close:
+			depth++
+		case open:
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// lineOf returns the index of the line containing pos
+func (s *SourceCode) lineOf(pos int) int {
+	i, _ := s.lines.Position(pos)
+	return i
+}
+
+// IndentLines adds a level of indentation to the selected lines which
+// aren't blank
+func (s *SourceCode) IndentLines() {
+	first, last := s.selectedLines()
+	var edits []edit
+	for i := first; i <= last; i++ {
+		line := s.Line(i)
+		if line.start+len(s.indentation(line)) != line.end {
+			edits = append(edits, edit{pos: line.start, text: s.indentUnit()})
+		}
+	}
+	s.applyEdits(edits)
+}
+
+// DedentLines removes a level of indentation from the selected lines: a
+// tab, or up to the width of the indentation unit in spaces
+func (s *SourceCode) DedentLines() {
+	first, last := s.selectedLines()
+	unit := s.indentUnit()
+	width := max(len(unit), 1)
+	if unit == "\t" {
+		width = 4
+	}
+
+	var edits []edit
+	for i := first; i <= last; i++ {
+		line := s.Line(i)
+		del := 0
+		if s.hasAt(line.start, unit) {
+			del = len(unit)
+		} else if line.start < line.end && s.data.ByteAt(line.start) == '\t' {
+			del = 1
+		} else {
+			for del < width && line.start+del < line.end && s.data.ByteAt(line.start+del) == ' ' {
+				del++
+			}
+		}
+		if del > 0 {
+			edits = append(edits, edit{pos: line.start, del: del})
+		}
+	}
+	s.applyEdits(edits)
+}
+
+// ReindentLines indents the selected lines as the indents query says.
+// Blank lines and lines the query can't indent are left alone.
+func (s *SourceCode) ReindentLines() {
+	first, last := s.selectedLines()
+	// Indentation doesn't change the tree, so every line is computed before
+	// editing any
+	var edits []edit
+	for i := first; i <= last; i++ {
+		line := s.Line(i)
+		current := s.indentation(line)
+		if line.start+len(current) == line.end {
+			continue
+		}
+		if indent, _, ok := s.treeIndent(i); ok && indent != current {
+			edits = append(edits, edit{pos: line.start, del: len(current), text: indent})
+		}
+	}
+	s.applyEdits(edits)
+}
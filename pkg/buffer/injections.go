@@ -0,0 +1,168 @@
+package buffer
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Ardelean-Calin/elmo/pkg/buffer/syntax"
+	"github.com/Ardelean-Calin/elmo/pkg/storage"
+	"github.com/Ardelean-Calin/elmo/pkg/themes"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// Language injections are parts of a file written in another language, like
+// the shell commands of a Dockerfile. The injections.scm query finds them,
+// then they get parsed with their own grammar and highlighted over the
+// colors of the host language.
+
+// maxInjectionDepth limits how deep languages injected into injected
+// languages go
+const maxInjectionDepth = 3
+
+// injection is a language found by the injections query, along with the
+// parts of the text written in it
+type injection struct {
+	name   string
+	ranges []sitter.Range
+}
+
+// inject highlights the languages injected into the tree over colors
+func inject(ctx context.Context, colors []themes.Scope, text storage.Text, root *sitter.Node, queries languageQueries, langs *syntax.Registry, depth int) {
+	query := queries.injections
+	if query == nil || langs == nil || depth >= maxInjectionDepth {
+		return
+	}
+
+	var injections []injection
+	combined := map[string]int{} // Index of the combined injections, by name
+	qc := sitter.NewQueryCursor()
+	defer qc.Close()
+	qc.Exec(query, root)
+	for ctx.Err() == nil {
+		m, ok := qc.NextMatch()
+		if !ok {
+			break
+		}
+		if !matchesPredicates(query, m, text) {
+			continue
+		}
+		name, combine, offset := injectionProperties(query, m, text)
+
+		var ranges []sitter.Range
+		for _, c := range m.Captures {
+			if query.CaptureNameForId(c.Index) == "injection.content" {
+				if r, ok := offsetRange(c.Node, offset); ok {
+					ranges = append(ranges, r)
+				}
+			}
+		}
+		if name == "" || len(ranges) == 0 {
+			continue
+		}
+		// Combined injections are parsed together, as a single document
+		if i, ok := combined[name]; ok && combine {
+			injections[i].ranges = append(injections[i].ranges, ranges...)
+			continue
+		}
+		if combine {
+			combined[name] = len(injections)
+		}
+		injections = append(injections, injection{name: name, ranges: ranges})
+	}
+
+	for _, inj := range injections {
+		language := langs.Injected(inj.name)
+		if language == nil || ctx.Err() != nil {
+			continue
+		}
+		q, err := loadQueries(language)
+		if err != nil {
+			continue
+		}
+		slices.SortFunc(inj.ranges, func(a, b sitter.Range) int {
+			return int(a.StartByte) - int(b.StartByte)
+		})
+
+		parser := sitter.NewParser()
+		parser.SetLanguage(language.SitterLanguage())
+		parser.SetIncludedRanges(inj.ranges)
+		tree, err := parser.ParseInputCtx(ctx, nil, textInput(ctx, text))
+		if err != nil {
+			return
+		}
+		highlight(ctx, colors, text, tree.RootNode(), q.highlights, inj.ranges)
+		inject(ctx, colors, text, tree.RootNode(), q, langs, depth+1)
+	}
+}
+
+// injectionProperties reads the injection settings of a match:
+//
+//   - the injected language, from the text of the @injection.language
+//     capture or from #set! injection.language "name"
+//   - whether all its matches are parsed together, with
+//     #set! injection.combined
+//   - how much to trim off the content, with
+//     #offset! @injection.content 0 1 0 -1 like the quotes of a string.
+//     Only column offsets are supported.
+func injectionProperties(q *sitter.Query, m *sitter.QueryMatch, text storage.Text) (name string, combined bool, offset [2]int) {
+	for _, steps := range q.PredicatesForPattern(uint32(m.PatternIndex)) {
+		// Every predicate ends with a "done" step
+		args := make([]string, len(steps)-1)
+		for i, step := range steps[:len(steps)-1] {
+			if step.Type == sitter.QueryPredicateStepTypeCapture {
+				args[i] = "@" + q.CaptureNameForId(step.ValueId)
+			} else {
+				args[i] = q.StringValueForId(step.ValueId)
+			}
+		}
+
+		switch {
+		case len(args) == 3 && args[0] == "set!" && args[1] == "injection.language":
+			name = args[2]
+		case len(args) == 2 && args[0] == "set!" && args[1] == "injection.combined":
+			combined = true
+		case len(args) == 6 && args[0] == "offset!" && args[1] == "@injection.content":
+			offset[0], _ = strconv.Atoi(args[3])
+			offset[1], _ = strconv.Atoi(args[5])
+		}
+	}
+
+	for _, c := range m.Captures {
+		if q.CaptureNameForId(c.Index) == "injection.language" {
+			name = string(nodeText(text, c.Node))
+		}
+	}
+	return languageName(name), combined, offset
+}
+
+// offsetRange returns the range of n, with its start and end moved by the
+// given number of bytes. Returns false if nothing is left.
+func offsetRange(n *sitter.Node, offset [2]int) (sitter.Range, bool) {
+	start, end := int(n.StartByte())+offset[0], int(n.EndByte())+offset[1]
+	if start >= end {
+		return sitter.Range{}, false
+	}
+	startPoint, endPoint := n.StartPoint(), n.EndPoint()
+	startPoint.Column = uint32(int(startPoint.Column) + offset[0])
+	endPoint.Column = uint32(int(endPoint.Column) + offset[1])
+	return sitter.Range{
+		StartPoint: startPoint,
+		EndPoint:   endPoint,
+		StartByte:  uint32(start),
+		EndByte:    uint32(end),
+	}, true
+}
+
+// languageName turns the text naming an injected language, like "/* sql */"
+// or "# bash", into the name alone
+func languageName(text string) string {
+	text = strings.TrimSpace(text)
+	text = strings.TrimSuffix(text, "*/")
+	for _, prefix := range []string{"/*", "//", "#"} {
+		text = strings.TrimPrefix(text, prefix)
+	}
+	return strings.ToLower(strings.TrimSpace(text))
+}
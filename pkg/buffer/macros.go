@@ -0,0 +1,217 @@
+package buffer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Macros. Q records the keys typed into a register until Q gets typed
+// again, and q types them again. Registers hold the keys in the notation of
+// Helix, like "ms(<esc>", so that macros can be written in the config too.
+
+// defaultRegister is used by Q and q when no register is selected with "
+const defaultRegister = "@"
+
+// keyNames are the names of the keys in the key notation, by type
+var keyNames = map[tea.KeyType]string{
+	tea.KeySpace:     "space",
+	tea.KeyEnter:     "ret",
+	tea.KeyEsc:       "esc",
+	tea.KeyTab:       "tab",
+	tea.KeyShiftTab:  "S-tab",
+	tea.KeyBackspace: "backspace",
+	tea.KeyDelete:    "del",
+	tea.KeyUp:        "up",
+	tea.KeyDown:      "down",
+	tea.KeyLeft:      "left",
+	tea.KeyRight:     "right",
+	tea.KeyHome:      "home",
+	tea.KeyEnd:       "end",
+	tea.KeyPgUp:      "pageup",
+	tea.KeyPgDown:    "pagedown",
+}
+
+// runeName returns the name of a character inside the key notation. The
+// angle brackets are named, as they delimit the other names.
+func runeName(r rune) string {
+	switch r {
+	case '<':
+		return "lt"
+	case '>':
+		return "gt"
+	}
+	return string(r)
+}
+
+// keyNotation returns a key in the key notation: characters as they are,
+// other keys named inside angle brackets, with C- and A- for ctrl and alt,
+// like "<C-a>" or "<A-o>". Returns "" for keys without a notation.
+func keyNotation(msg tea.KeyMsg) string {
+	mods := ""
+	if msg.Alt {
+		mods = "A-"
+	}
+
+	var name string
+	switch {
+	case msg.Type == tea.KeyRunes && !msg.Alt:
+		var sb strings.Builder
+		for _, r := range msg.Runes {
+			if name := runeName(r); len(name) > 1 {
+				sb.WriteString("<" + name + ">")
+			} else {
+				sb.WriteString(name)
+			}
+		}
+		return sb.String()
+	case msg.Type == tea.KeyRunes && len(msg.Runes) == 1:
+		name = runeName(msg.Runes[0])
+	case keyNames[msg.Type] != "":
+		name = keyNames[msg.Type]
+	case msg.Type >= tea.KeyCtrlA && msg.Type <= tea.KeyCtrlZ:
+		name = "C-" + string(rune('a'+msg.Type-tea.KeyCtrlA))
+	default:
+		return ""
+	}
+	return "<" + mods + name + ">"
+}
+
+// parseKeys reads keys written in the key notation
+func parseKeys(notation string) ([]tea.KeyMsg, error) {
+	var keys []tea.KeyMsg
+	for len(notation) > 0 {
+		if notation[0] != '<' {
+			r := []rune(notation)[0]
+			notation = notation[len(string(r)):]
+			if r == ' ' {
+				keys = append(keys, tea.KeyMsg{Type: tea.KeySpace, Runes: []rune{' '}})
+			} else {
+				keys = append(keys, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+			}
+			continue
+		}
+
+		end := strings.IndexByte(notation, '>')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed key %q", notation)
+		}
+		key, err := parseKey(notation[1:end])
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		notation = notation[end+1:]
+	}
+	return keys, nil
+}
+
+// parseKey reads the name of a key, found between angle brackets
+func parseKey(name string) (tea.KeyMsg, error) {
+	var key tea.KeyMsg
+	key.Alt = strings.HasPrefix(name, "A-")
+	rest := strings.TrimPrefix(name, "A-")
+
+	for t, n := range keyNames {
+		if n == rest {
+			key.Type = t
+			if t == tea.KeySpace {
+				key.Runes = []rune{' '}
+			}
+			return key, nil
+		}
+	}
+	if letter, ok := strings.CutPrefix(rest, "C-"); ok && len(letter) == 1 && 'a' <= letter[0] && letter[0] <= 'z' {
+		key.Type = tea.KeyCtrlA + tea.KeyType(letter[0]-'a')
+		return key, nil
+	}
+	switch rest {
+	case "lt":
+		rest = "<"
+	case "gt":
+		rest = ">"
+	}
+	if utf8.RuneCountInString(rest) == 1 {
+		key.Type, key.Runes = tea.KeyRunes, []rune(rest)
+		return key, nil
+	}
+	return key, fmt.Errorf("unknown key <%s>", name)
+}
+
+// CheckMacros reports the macros of the config which can't be replayed,
+// either because of their key notation or of their register, which must be
+// a single character
+func CheckMacros(macros map[string]string) error {
+	registers := make([]string, 0, len(macros))
+	for register := range macros {
+		registers = append(registers, register)
+	}
+	slices.Sort(registers)
+
+	// The errors are shown on the single line of the footer
+	var errs []string
+	for _, register := range registers {
+		if !isCharKey(register) {
+			errs = append(errs, fmt.Sprintf("macro %q: registers are single characters", register))
+		} else if _, err := parseKeys(macros[register]); err != nil {
+			errs = append(errs, fmt.Sprintf("macro %s: %v", register, err))
+		}
+	}
+	if errs == nil {
+		return nil
+	}
+	return errors.New(strings.Join(errs, "; "))
+}
+
+// selectedRegister returns the register selected for the command, or the
+// default one
+func (m *Model) selectedRegister() string {
+	if m.register == "" {
+		return defaultRegister
+	}
+	return m.register
+}
+
+// toggleRecording starts recording a macro into the selected register, or
+// stops the recording and saves it
+func (m *Model) toggleRecording() {
+	if m.recording == "" {
+		m.recording, m.macro = m.selectedRegister(), nil
+		return
+	}
+	var sb strings.Builder
+	for _, msg := range m.macro {
+		sb.WriteString(keyNotation(msg))
+	}
+	if m.registers == nil {
+		m.registers = map[string]string{}
+	}
+	m.registers[m.recording] = sb.String()
+	m.recording, m.macro = "", nil
+}
+
+// replayMacro types the keys of the selected register, count times. Macros
+// can't replay macros, so that they never loop forever.
+func (m *Model) replayMacro() {
+	if m.replaying > 0 {
+		return
+	}
+	register, count := m.selectedRegister(), max(m.count, 1)
+	notation, ok := m.registers[register]
+	if !ok {
+		notation = m.config.Macros[register]
+	}
+	keys, err := parseKeys(notation)
+	if err != nil {
+		log.Printf("Macro %s: %v", register, err)
+		return
+	}
+	for ; count > 0; count-- {
+		m.replay(keys)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package buffer
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads the file into memory on systems without mmap support
+func mapFile(fd *os.File, size int64) ([]byte, error) {
+	return io.ReadAll(io.LimitReader(fd, size))
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package buffer
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps the first size bytes of the file read-only into memory. Pages
+// are only loaded from disk once they are accessed.
+func mapFile(fd *os.File, size int64) ([]byte, error) {
+	return syscall.Mmap(int(fd.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
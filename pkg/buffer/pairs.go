@@ -0,0 +1,112 @@
+package buffer
+
+import (
+	"strings"
+
+	"github.com/Ardelean-Calin/elmo/pkg/buffer/syntax"
+)
+
+// Auto-pairs. Typing an opening bracket or quote in insert mode types the
+// closing one too, typing the closing one moves over it, and deleting the
+// opening one of an empty pair deletes both. The pairs come from the
+// language, see syntax.Language.AutoPairs.
+
+// autoPairs returns the characters closing the auto-pairs, by opening
+// character
+func (s *SourceCode) autoPairs() map[byte]byte {
+	if s.pairs == nil {
+		return syntax.DefaultAutoPairs
+	}
+	return s.pairs
+}
+
+// closesPair reports whether c closes one of the auto-pairs
+func (s *SourceCode) closesPair(c byte) bool {
+	for _, close := range s.autoPairs() {
+		if close == c {
+			return true
+		}
+	}
+	return false
+}
+
+// isWordByte reports whether c is part of a word
+func isWordByte(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// inStringOrComment reports whether the syntax tree has a string or a
+// comment around pos. Typing keeps the tree outdated, which is good enough
+// here: the last parsed tree is used as is, with the edits made since moving
+// its nodes. Until the first parse is delivered, nothing is in a string.
+func (s *SourceCode) inStringOrComment(pos int) bool {
+	if s.tree == nil || pos == 0 {
+		return false
+	}
+	root := s.tree.RootNode()
+	for n := descendantAt(root, pos-1); n != nil; n = n.Parent() {
+		kind := n.Type()
+		if !strings.Contains(kind, "string") && !strings.Contains(kind, "comment") {
+			continue
+		}
+		end := int(n.EndByte())
+		// Strings end with their closing quote, while line comments go on
+		// until the end of the line
+		if pos < end {
+			return true
+		}
+		return strings.Contains(kind, "comment") && !strings.HasSuffix(string(s.data.Slice(end-2, end)), "*/")
+	}
+	return false
+}
+
+// shouldPair reports whether typing open at the cursor also types close
+func (s *SourceCode) shouldPair(open, close byte) bool {
+	// Pairs only go in front of nothing, whitespace or closing characters
+	if s.cursor < s.data.Len() {
+		next := s.data.ByteAt(s.cursor)
+		if !isSpace(next) && !s.closesPair(next) {
+			return false
+		}
+	}
+	// Quotes right after a word are apostrophes, like in "don't"
+	if open == close && s.cursor > 0 {
+		if prev := s.data.ByteAt(s.cursor - 1); isWordByte(prev) || prev == open {
+			return false
+		}
+	}
+	return !s.inStringOrComment(s.cursor)
+}
+
+// InsertChar types c at the cursor in insert mode, taking care of the
+// auto-pairs and of dedenting closing brackets
+func (s *SourceCode) InsertChar(c byte) {
+	if s.cursor < s.data.Len() && s.data.ByteAt(s.cursor) == c && s.closesPair(c) {
+		s.cursor++
+		return
+	}
+	if close, ok := s.autoPairs()[c]; ok && s.shouldPair(c, close) {
+		s.Insert([]byte{c, close})
+		s.cursor--
+		return
+	}
+	if closingBrackets[c] != 0 {
+		s.InsertClosing(c)
+		return
+	}
+	if isWordByte(c) {
+		s.InsertWordChar(c)
+		return
+	}
+	s.Insert([]byte{c})
+}
+
+// emptyPair reports whether the cursor is between the two characters of an
+// auto-pair
+func (s *SourceCode) emptyPair() bool {
+	if s.cursor == 0 || s.cursor >= s.data.Len() {
+		return false
+	}
+	close, ok := s.autoPairs()[s.data.ByteAt(s.cursor-1)]
+	return ok && s.data.ByteAt(s.cursor) == close
+}
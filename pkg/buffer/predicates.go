@@ -0,0 +1,93 @@
+package buffer
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/Ardelean-Calin/elmo/pkg/storage"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// Query predicates, like (#match? @constant "^[A-Z]"), are checked here
+// rather than with go-tree-sitter, which wants all of the text in a single
+// slice. Only the text of the captured nodes gets read from the storage.
+
+// regexps caches the compiled expressions of the #match? predicates. Queries
+// run on the background parses too, hence the sync.Map.
+var regexps sync.Map
+
+// compiledRegexp returns the compiled expr, or nil if it is invalid
+func compiledRegexp(expr string) *regexp.Regexp {
+	if re, ok := regexps.Load(expr); ok {
+		return re.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil
+	}
+	regexps.Store(expr, re)
+	return re
+}
+
+// nodeText returns a copy of the text of n
+func nodeText(text storage.Text, n *sitter.Node) []byte {
+	return text.Slice(int(n.StartByte()), int(n.EndByte()))
+}
+
+// matchesPredicates reports whether the captures of m satisfy the #eq? and
+// #match? predicates of their pattern, or their #not- versions. Other
+// predicates, like #set!, are left to the code using the query. A match with
+// an invalid regular expression never satisfies it.
+func matchesPredicates(q *sitter.Query, m *sitter.QueryMatch, text storage.Text) bool {
+	for _, steps := range q.PredicatesForPattern(uint32(m.PatternIndex)) {
+		if len(steps) < 3 || steps[1].Type != sitter.QueryPredicateStepTypeCapture {
+			continue
+		}
+		operator := q.StringValueForId(steps[0].ValueId)
+		want := !strings.HasPrefix(operator, "not-")
+
+		var matches func(content []byte) bool
+		switch strings.TrimPrefix(operator, "not-") {
+		case "eq?":
+			var value []byte
+			if steps[2].Type == sitter.QueryPredicateStepTypeCapture {
+				other := capturedNode(m, steps[2].ValueId)
+				if other == nil {
+					continue
+				}
+				value = nodeText(text, other)
+			} else {
+				value = []byte(q.StringValueForId(steps[2].ValueId))
+			}
+			matches = func(content []byte) bool { return bytes.Equal(content, value) }
+		case "match?":
+			re := compiledRegexp(q.StringValueForId(steps[2].ValueId))
+			if re == nil {
+				return false
+			}
+			matches = re.Match
+		default:
+			continue
+		}
+
+		for _, c := range m.Captures {
+			if c.Index == steps[1].ValueId && matches(nodeText(text, c.Node)) != want {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// capturedNode returns the first node of m captured with the given id
+func capturedNode(m *sitter.QueryMatch, id uint32) *sitter.Node {
+	for _, c := range m.Captures {
+		if c.Index == id {
+			return c.Node
+		}
+	}
+	return nil
+}
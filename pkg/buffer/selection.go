@@ -0,0 +1,159 @@
+package buffer
+
+import (
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// selection is the selection state of a SourceCode, as it was at some point
+type selection struct {
+	anchor, end, cursor int
+}
+
+// selection returns the current selection state
+func (s *SourceCode) selection() selection {
+	return selection{anchor: s.selectAnchor, end: s.selectEnd, cursor: s.cursor}
+}
+
+// setSelection restores a selection state
+func (s *SourceCode) setSelection(sel selection) {
+	s.selectAnchor, s.selectEnd, s.cursor = sel.anchor, sel.end, sel.cursor
+	s.RelalcHpos()
+}
+
+// selections returns the ranges of every selection. There is a single one
+// for now.
+func (s *SourceCode) selections() []textRange {
+	start, end := s.GetSelection()
+	return []textRange{{start, min(end+1, s.data.Len())}}
+}
+
+// syntaxTree returns the syntax tree, or nil if there is none or if it is
+// outdated because the content was edited since it got parsed
+func (s *SourceCode) syntaxTree() *sitter.Node {
+	if s.tree == nil || s.parsed != s.version {
+		return nil
+	}
+	return s.tree.RootNode()
+}
+
+// selectNode selects the text of a node, with the cursor on its last
+// character
+func (s *SourceCode) selectNode(n *sitter.Node) {
+	s.setSelection(selection{
+		anchor: int(n.StartByte()),
+		end:    int(n.EndByte()) - 1,
+		cursor: int(n.EndByte()) - 1,
+	})
+	s.structural = s.selection()
+}
+
+// coveringNode returns the smallest named node containing the whole range
+// [start, end)
+func coveringNode(root *sitter.Node, start, end int) *sitter.Node {
+	node := root
+	for {
+		var next *sitter.Node
+		for i := 0; i < int(node.NamedChildCount()); i++ {
+			child := node.NamedChild(i)
+			if child.StartByte() < child.EndByte() && int(child.StartByte()) <= start && end <= int(child.EndByte()) {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			return node
+		}
+		node = next
+	}
+}
+
+// selectedNode returns the node covering the selection, and whether the
+// selection is exactly that node
+func (s *SourceCode) selectedNode(root *sitter.Node) (*sitter.Node, bool) {
+	start, end := s.GetSelection()
+	end = min(end+1, s.data.Len())
+	node := coveringNode(root, start, end)
+	return node, int(node.StartByte()) == start && int(node.EndByte()) == end
+}
+
+// ExpandSelection selects the syntax node around the selection. The
+// previous selection is remembered, so ShrinkSelection can go back to it.
+func (s *SourceCode) ExpandSelection() {
+	root := s.freshTree()
+	if root == nil {
+		return
+	}
+	node, exact := s.selectedNode(root)
+	// Nodes can have a parent spanning the same text, those are skipped
+	for exact {
+		parent := node.Parent()
+		if parent == nil {
+			return
+		}
+		if parent.StartByte() != node.StartByte() || parent.EndByte() != node.EndByte() {
+			exact = false
+		}
+		node = parent
+	}
+
+	if s.selection() != s.structural {
+		// The selection was changed by hand, start over
+		s.selectionStack = s.selectionStack[:0]
+	}
+	s.selectionStack = append(s.selectionStack, s.selection())
+	s.selectNode(node)
+}
+
+// ShrinkSelection goes back to the selection before the last
+// ExpandSelection. Without one, it selects the first child node of the
+// selection instead.
+func (s *SourceCode) ShrinkSelection() {
+	if len(s.selectionStack) > 0 && s.selection() == s.structural {
+		prev := s.selectionStack[len(s.selectionStack)-1]
+		s.selectionStack = s.selectionStack[:len(s.selectionStack)-1]
+		s.setSelection(prev)
+		s.structural = prev
+		return
+	}
+	s.selectionStack = s.selectionStack[:0]
+
+	root := s.freshTree()
+	if root == nil {
+		return
+	}
+	node, _ := s.selectedNode(root)
+	for i := 0; i < int(node.NamedChildCount()); i++ {
+		if child := node.NamedChild(i); child.StartByte() < child.EndByte() {
+			s.selectNode(child)
+			return
+		}
+	}
+}
+
+// SelectNextSibling selects the syntax node after the selected one. If it
+// is the last one, it continues with the siblings of its parent.
+func (s *SourceCode) SelectNextSibling() {
+	s.selectSibling((*sitter.Node).NextNamedSibling)
+}
+
+// SelectPrevSibling selects the syntax node before the selected one. If it
+// is the first one, it continues with the siblings of its parent.
+func (s *SourceCode) SelectPrevSibling() {
+	s.selectSibling((*sitter.Node).PrevNamedSibling)
+}
+
+func (s *SourceCode) selectSibling(sibling func(*sitter.Node) *sitter.Node) {
+	root := s.freshTree()
+	if root == nil {
+		return
+	}
+	node, _ := s.selectedNode(root)
+	for node != nil {
+		if next := sibling(node); next != nil {
+			s.selectionStack = s.selectionStack[:0]
+			s.selectNode(next)
+			return
+		}
+		node = node.Parent()
+	}
+}
@@ -0,0 +1,133 @@
+package buffer
+
+// Surround editing adds, replaces and deletes the pair of characters around
+// the selection, like the brackets around an argument or the quotes of a
+// string.
+
+// surroundPair returns the characters opening and closing a pair, given
+// either of them. Characters other than brackets surround with themselves.
+func surroundPair(c string) (open, close string) {
+	switch c {
+	case "(", ")":
+		return "(", ")"
+	case "[", "]":
+		return "[", "]"
+	case "{", "}":
+		return "{", "}"
+	case "<", ">":
+		return "<", ">"
+	}
+	return c, c
+}
+
+// hasAt reports whether text is found at pos
+func (s *SourceCode) hasAt(pos int, text string) bool {
+	return pos >= 0 && pos+len(text) <= s.data.Len() && string(s.data.Slice(pos, pos+len(text))) == text
+}
+
+// findSurrounding returns the positions of the pair of c around the range
+// [start, end], which may be part of the pair itself. Nested pairs of
+// brackets are skipped. Returns false if there is no such pair.
+func (s *SourceCode) findSurrounding(c string, start, end int) (int, int, bool) {
+	open, close := surroundPair(c)
+	nested := open != close
+
+	left := -1
+	depth := 0
+	for i := start; i >= 0; i-- {
+		if nested && i != start && s.hasAt(i, close) {
+			depth++
+		} else if s.hasAt(i, open) {
+			if depth == 0 {
+				left = i
+				break
+			}
+			depth--
+		}
+	}
+	if left < 0 {
+		return 0, 0, false
+	}
+
+	depth = 0
+	for i := max(end, left+len(open)); i < s.data.Len(); i++ {
+		if nested && i != end && s.hasAt(i, open) {
+			depth++
+		} else if s.hasAt(i, close) {
+			if depth == 0 {
+				return left, i, true
+			}
+			depth--
+		}
+	}
+	return 0, 0, false
+}
+
+// Surround puts the pair of c around the selection, and selects it along
+// with the pair
+func (s *SourceCode) Surround(c string) {
+	open, close := surroundPair(c)
+	start, end := s.GetSelection()
+	end = min(end+1, s.data.Len())
+
+	s.insertAt(end, []byte(close))
+	s.insertAt(start, []byte(open))
+	last := end + len(open) + len(close) - 1
+	s.setSelection(selection{anchor: start, end: last, cursor: last})
+}
+
+// ReplaceSurround replaces the pair of from around the selection by the
+// pair of to
+func (s *SourceCode) ReplaceSurround(from, to string) {
+	sel := s.selection()
+	start, end := s.GetSelection()
+	left, right, ok := s.findSurrounding(from, start, end)
+	if !ok {
+		return
+	}
+	oldOpen, oldClose := surroundPair(from)
+	open, close := surroundPair(to)
+
+	s.deleteAt(right, right+len(oldClose))
+	s.insertAt(right, []byte(close))
+	s.deleteAt(left, left+len(oldOpen))
+	s.insertAt(left, []byte(open))
+	shift := func(pos int) int {
+		if pos > right {
+			pos += len(close) - len(oldClose)
+		}
+		if pos > left {
+			pos += len(open) - len(oldOpen)
+		}
+		return pos
+	}
+	s.setSelection(selection{anchor: shift(sel.anchor), end: shift(sel.end), cursor: shift(sel.cursor)})
+}
+
+// DeleteSurround deletes the pair of c around the selection
+func (s *SourceCode) DeleteSurround(c string) {
+	sel := s.selection()
+	start, end := s.GetSelection()
+	left, right, ok := s.findSurrounding(c, start, end)
+	if !ok {
+		return
+	}
+	open, close := surroundPair(c)
+
+	s.deleteAt(right, right+len(close))
+	s.deleteAt(left, left+len(open))
+	shift := func(pos int) int {
+		if pos >= right+len(close) {
+			pos -= len(close)
+		} else if pos >= right {
+			pos = right - 1
+		}
+		if pos >= left+len(open) {
+			pos -= len(open)
+		} else if pos >= left {
+			pos = left
+		}
+		return max(pos, 0)
+	}
+	s.setSelection(selection{anchor: shift(sel.anchor), end: shift(sel.end), cursor: shift(sel.cursor)})
+}
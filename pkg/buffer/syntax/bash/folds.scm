@@ -0,0 +1,11 @@
+[
+  (function_definition)
+  (compound_statement)
+  (if_statement)
+  (for_statement)
+  (while_statement)
+  (case_statement)
+  (heredoc_body)
+] @fold
+
+(comment)+ @fold
@@ -0,0 +1,87 @@
+(variable_name) @variable
+
+(command_name) @function
+
+((command_name (word) @function.builtin)
+  (#match? @function.builtin "^(alias|bg|bind|break|builtin|caller|cd|command|compgen|complete|continue|declare|dirs|disown|echo|enable|eval|exec|exit|export|false|fc|fg|getopts|hash|help|history|jobs|kill|let|local|logout|mapfile|popd|printf|pushd|pwd|read|readarray|readonly|return|set|shift|shopt|source|suspend|test|times|trap|true|type|typeset|ulimit|umask|unalias|unset|wait)$"))
+
+(function_definition
+  name: (word) @function)
+
+(file_descriptor) @number
+
+[
+  (string)
+  (raw_string)
+  (ansii_c_string)
+  (heredoc_body)
+] @string
+
+(heredoc_start) @label
+
+(comment) @comment
+
+(command
+  argument: (word) @variable.parameter
+  (#match? @variable.parameter "^-"))
+
+(special_variable_name) @variable.builtin
+
+[
+  (command_substitution)
+  (expansion)
+  (simple_expansion)
+] @embedded
+
+[
+  "$"
+  "&&"
+  ">"
+  ">>"
+  "<"
+  "|"
+  "||"
+  "="
+  "=="
+  "!="
+] @operator
+
+[
+  "("
+  ")"
+  "(("
+  "))"
+  "{"
+  "}"
+  "["
+  "]"
+  "[["
+  "]]"
+] @punctuation.bracket
+
+[
+  ";"
+  ";;"
+] @punctuation.delimiter
+
+[
+  "case"
+  "declare"
+  "do"
+  "done"
+  "elif"
+  "else"
+  "esac"
+  "export"
+  "fi"
+  "for"
+  "function"
+  "if"
+  "in"
+  "local"
+  "readonly"
+  "then"
+  "typeset"
+  "unset"
+  "while"
+] @keyword
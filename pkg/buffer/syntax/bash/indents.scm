@@ -0,0 +1,17 @@
+[
+  (compound_statement)
+  (do_group)
+  (if_statement)
+  (case_item)
+  (array)
+] @indent
+
+[
+  "}"
+  ")"
+  "done"
+  "fi"
+  "elif"
+  "else"
+  "esac"
+] @outdent
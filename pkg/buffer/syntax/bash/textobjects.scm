@@ -0,0 +1,9 @@
+(function_definition
+  body: (_) @function.inside) @function.around
+
+(command
+  argument: (_) @parameter.inside @parameter.around)
+
+(comment) @comment.inside
+
+(comment)+ @comment.around
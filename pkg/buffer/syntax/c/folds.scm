@@ -0,0 +1,12 @@
+[
+  (function_definition)
+  (compound_statement)
+  (struct_specifier)
+  (enum_specifier)
+  (union_specifier)
+  (initializer_list)
+  (preproc_if)
+  (preproc_ifdef)
+] @fold
+
+(comment)+ @fold
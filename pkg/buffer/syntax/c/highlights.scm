@@ -0,0 +1,130 @@
+(identifier) @variable
+
+((identifier) @constant
+  (#match? @constant "^[A-Z][A-Z_0-9]*$"))
+
+(field_identifier) @variable.member
+(statement_identifier) @label
+
+(type_identifier) @type
+(primitive_type) @type.builtin
+(sized_type_specifier) @type.builtin
+
+(parameter_declaration
+  declarator: (identifier) @variable.parameter)
+
+(parameter_declaration
+  declarator: (pointer_declarator
+    declarator: (identifier) @variable.parameter))
+
+(call_expression
+  function: (identifier) @function)
+
+(call_expression
+  function: (field_expression
+    field: (field_identifier) @function.method))
+
+(function_declarator
+  declarator: (identifier) @function)
+
+(preproc_function_def
+  name: (identifier) @function.macro)
+
+(preproc_def
+  name: (identifier) @constant)
+
+[
+  (true)
+  (false)
+  (null)
+] @constant.builtin
+
+(number_literal) @number
+(char_literal) @string
+(string_literal) @string
+(system_lib_string) @string.special.path
+(escape_sequence) @escape
+
+(comment) @comment
+
+[
+  "#define"
+  "#elif"
+  "#else"
+  "#endif"
+  "#if"
+  "#ifdef"
+  "#ifndef"
+  "#include"
+  (preproc_directive)
+] @keyword
+
+[
+  "--"
+  "-"
+  "-="
+  "->"
+  "="
+  "!="
+  "*"
+  "&"
+  "&&"
+  "+"
+  "++"
+  "+="
+  "<"
+  "=="
+  ">"
+  "||"
+  "!"
+  "~"
+  "|"
+  "^"
+  "<<"
+  ">>"
+  "<="
+  ">="
+  "/"
+  "%"
+] @operator
+
+[
+  "("
+  ")"
+  "["
+  "]"
+  "{"
+  "}"
+] @punctuation.bracket
+
+[
+  "."
+  ";"
+  ","
+  ":"
+] @punctuation.delimiter
+
+[
+  "break"
+  "case"
+  "const"
+  "continue"
+  "default"
+  "do"
+  "else"
+  "enum"
+  "extern"
+  "for"
+  "goto"
+  "if"
+  "inline"
+  "return"
+  "sizeof"
+  "static"
+  "struct"
+  "switch"
+  "typedef"
+  "union"
+  "volatile"
+  "while"
+] @keyword
@@ -0,0 +1,17 @@
+[
+  (compound_statement)
+  (field_declaration_list)
+  (enumerator_list)
+  (initializer_list)
+  (argument_list)
+  (parameter_list)
+  (case_statement)
+] @indent
+
+[
+  "case"
+  "default"
+  "}"
+  ")"
+  "]"
+] @outdent
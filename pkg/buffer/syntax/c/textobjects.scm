@@ -0,0 +1,21 @@
+(function_definition
+  body: (_) @function.inside) @function.around
+
+(struct_specifier
+  body: (_) @class.inside) @class.around
+
+(union_specifier
+  body: (_) @class.inside) @class.around
+
+(enum_specifier
+  body: (_) @class.inside) @class.around
+
+(parameter_list
+  (_) @parameter.inside @parameter.around . ","? @parameter.around)
+
+(argument_list
+  (_) @parameter.inside @parameter.around . ","? @parameter.around)
+
+(comment) @comment.inside
+
+(comment)+ @comment.around
@@ -0,0 +1,55 @@
+[
+  "FROM"
+  "AS"
+  "RUN"
+  "CMD"
+  "LABEL"
+  "EXPOSE"
+  "ENV"
+  "ADD"
+  "COPY"
+  "ENTRYPOINT"
+  "VOLUME"
+  "USER"
+  "WORKDIR"
+  "ARG"
+  "ONBUILD"
+  "STOPSIGNAL"
+  "HEALTHCHECK"
+  "SHELL"
+  "MAINTAINER"
+  "CROSS_BUILD"
+] @keyword
+
+[
+  ":"
+  "@"
+] @operator
+
+(comment) @comment
+
+(image_spec
+  (image_tag
+    ":" @punctuation.special)
+  (image_digest
+    "@" @punctuation.special))
+
+(double_quoted_string) @string
+
+(expansion
+  [
+    "$"
+    "{"
+    "}"
+  ] @punctuation.special) @embedded
+
+((variable) @constant
+  (#match? @constant "^[A-Z][A-Z_0-9]*$"))
+
+(param) @attribute
+(escape_sequence) @escape
+
+[
+  "["
+  "]"
+] @punctuation.bracket
@@ -0,0 +1,3 @@
+; The commands of RUN, CMD and the like are shell scripts
+((shell_command) @injection.content
+  (#set! injection.language "bash"))
@@ -0,0 +1,20 @@
+; Code which can be folded with zc. Captures with the same name in one match
+; are joined, so consecutive comments fold together.
+
+[
+  (function_declaration)
+  (method_declaration)
+  (func_literal)
+  (block)
+  (import_declaration)
+  (const_declaration)
+  (var_declaration)
+  (type_declaration)
+  (literal_value)
+  (expression_case)
+  (default_case)
+  (type_case)
+  (communication_case)
+] @fold
+
+(comment)+ @fold
@@ -0,0 +1,29 @@
+; Indentation of new lines. Lines inside an @indent node get indented one
+; level more than the line the node starts on, several @indent nodes
+; starting on the same line count once. A line starting with an @outdent
+; node gets one level less, so cases line up with their switch, as gofmt
+; does.
+
+[
+  (import_declaration)
+  (const_declaration)
+  (var_declaration)
+  (type_declaration)
+  (block)
+  (literal_value)
+  (field_declaration_list)
+  (interface_type)
+  (argument_list)
+  (parameter_list)
+  (expression_switch_statement)
+  (type_switch_statement)
+  (select_statement)
+] @indent
+
+[
+  "case"
+  "default"
+  "}"
+  ")"
+  "]"
+] @outdent
@@ -0,0 +1,38 @@
+; Languages embedded into Go. Injected text gets parsed with the grammar of
+; its language and highlighted over the Go colors. The language is the text
+; of the @injection.language capture, or set with
+; (#set! injection.language "name"). #offset! trims the content, like the
+; quotes around a string.
+
+; Strings following a comment naming their language, like
+; /* sql */ `SELECT * FROM users`
+((comment) @injection.language
+  .
+  [
+    (raw_string_literal)
+    (interpreted_string_literal)
+  ] @injection.content
+  (#offset! @injection.content 0 1 0 -1))
+
+((comment) @injection.language
+  .
+  (expression_list
+    .
+    [
+      (raw_string_literal)
+      (interpreted_string_literal)
+    ] @injection.content)
+  (#offset! @injection.content 0 1 0 -1))
+
+; Regular expressions
+(call_expression
+  function: (selector_expression) @_function
+  (#match? @_function "^regexp\\.(Match|MatchReader|MatchString|Compile|CompilePOSIX|MustCompile|MustCompilePOSIX)$")
+  arguments: (argument_list
+    .
+    [
+      (raw_string_literal)
+      (interpreted_string_literal)
+    ] @injection.content)
+  (#offset! @injection.content 0 1 0 -1)
+  (#set! injection.language "regex"))
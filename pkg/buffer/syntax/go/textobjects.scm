@@ -0,0 +1,34 @@
+; Text objects, selected with mif, mac, ]f and so on. Captures named
+; <object>.inside and <object>.around select the inside or the whole object.
+; Captures with the same name in one match are joined.
+
+(function_declaration
+  body: (block)? @function.inside) @function.around
+
+(method_declaration
+  body: (block)? @function.inside) @function.around
+
+(func_literal
+  body: (block)? @function.inside) @function.around
+
+(type_declaration
+  (type_spec
+    type: (struct_type
+      (field_declaration_list) @class.inside))) @class.around
+
+(type_declaration
+  (type_spec
+    type: (interface_type) @class.inside)) @class.around
+
+(parameter_list
+  (_) @parameter.inside @parameter.around . ","? @parameter.around)
+
+(argument_list
+  (_) @parameter.inside @parameter.around . ","? @parameter.around)
+
+(type_parameter_list
+  (_) @parameter.inside @parameter.around . ","? @parameter.around)
+
+(comment) @comment.inside
+
+(comment)+ @comment.around
@@ -0,0 +1,6 @@
+[
+  (compound_statement)
+  (subshell)
+] @fold
+
+(comment)+ @fold
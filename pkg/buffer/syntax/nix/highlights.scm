@@ -1,74 +1,32 @@
+; Only node types of the nix grammar compiled into elmo, which is built on
+; the bash one (see go-tree-sitter/nix), so that the query compiles
+
 (comment) @comment
 
 [
   "if"
   "then"
   "else"
-  "let"
-  "inherit"
   "in"
-  "rec"
-  "with"
-  "assert"
-  "or"
 ] @keyword
 
-((identifier) @variable.builtin
- (#match? @variable.builtin "^(__currentSystem|__currentTime|__nixPath|__nixVersion|__storeDir|builtins|false|null|true)$")
- )
-
-((identifier) @function.builtin
- (#match? @function.builtin "^(__add|__addErrorContext|__all|__any|__appendContext|__attrNames|__attrValues|__bitAnd|__bitOr|__bitXor|__catAttrs|__compareVersions|__concatLists|__concatMap|__concatStringsSep|__deepSeq|__div|__elem|__elemAt|__fetchurl|__filter|__filterSource|__findFile|__foldl'|__fromJSON|__functionArgs|__genList|__genericClosure|__getAttr|__getContext|__getEnv|__hasAttr|__hasContext|__hashFile|__hashString|__head|__intersectAttrs|__isAttrs|__isBool|__isFloat|__isFunction|__isInt|__isList|__isPath|__isString|__langVersion|__length|__lessThan|__listToAttrs|__mapAttrs|__match|__mul|__parseDrvName|__partition|__path|__pathExists|__readDir|__readFile|__replaceStrings|__seq|__sort|__split|__splitVersion|__storePath|__stringLength|__sub|__substring|__tail|__toFile|__toJSON|__toPath|__toXML|__trace|__tryEval|__typeOf|__unsafeDiscardOutputDependency|__unsafeDiscardStringContext|__unsafeGetAttrPos|__valueSize|abort|baseNameOf|derivation|derivationStrict|dirOf|fetchGit|fetchMercurial|fetchTarball|fromTOML|import|isNull|map|placeholder|removeAttrs|scopedImport|throw|toString)$")
- )
-
 [
-  (integer_expression)
-  (float_expression)
-] @number
-
-(escape_sequence) @escape
-(dollar_escape) @escape
-
-(function_expression
-  universal: (identifier) @variable.parameter
-)
-
-(formal
-  name: (identifier) @variable.parameter
-  "?"? @punctuation.delimiter)
-
-(select_expression
-  attrpath: (attrpath (identifier)) @property)
-
-(apply_expression
-  function: [
-    (variable_expression (identifier)) @function
-    (select_expression
-      attrpath: (attrpath
-        attr: (identifier) @function .))])
-
-(unary_expression
-  operator: _ @operator)
-
-(binary_expression
-  operator: _ @operator)
-
-(variable_expression (identifier) @variable)
-
-(binding
-  attrpath: (attrpath (identifier)) @property)
+  (string)
+  (raw_string)
+] @string
 
-(identifier) @property
+(variable_name) @variable
 
-(inherit_from attrs: (inherited_attrs attr: (identifier) @property) )
+(simple_expansion) @embedded
 
 [
   ";"
-  "."
-  ","
-  "="
 ] @punctuation.delimiter
 
+[
+  "="
+] @operator
+
 [
   "("
   ")"
@@ -77,23 +35,3 @@
   "{"
   "}"
 ] @punctuation.bracket
-
-(identifier) @variable
-
-[
-  (string_expression)
-  (indented_string_expression)
-] @string
-
-[
-  (path_expression)
-  (hpath_expression)
-  (spath_expression)
-] @string.special.path
-
-(uri_expression) @string.special.uri
-
-(interpolation
-  "${" @punctuation.special
-  (_) @embedded
-  "}" @punctuation.special)
@@ -0,0 +1,9 @@
+[
+  (compound_statement)
+  (subshell)
+] @indent
+
+[
+  "}"
+  ")"
+] @outdent
@@ -0,0 +1,16 @@
+; Shell scripts embedded into Nix, like the build phases of a derivation.
+; Nix writes them in indented strings, the indented_string_expression of
+; the Nix grammar. The nix grammar compiled into elmo is built on the bash
+; one though (see go-tree-sitter/nix), where an attribute is a command
+; with "=" and its value as arguments. Until it's replaced, only the double
+; quoted values of these attributes have their shell highlighted.
+
+((command
+  name: (command_name (word) @_attribute)
+  argument: (word) @_equals
+  .
+  argument: (string) @injection.content)
+  (#eq? @_equals "=")
+  (#match? @_attribute "^(\\w*Phase|(pre|post)\\w*|\\w*[sS]cript|\\w*[hH]ook)$")
+  (#offset! @injection.content 0 1 0 -1)
+  (#set! injection.language "bash"))
@@ -0,0 +1,15 @@
+[
+  (function_definition)
+  (class_definition)
+  (if_statement)
+  (for_statement)
+  (while_statement)
+  (with_statement)
+  (try_statement)
+  (match_statement)
+  (dictionary)
+  (list)
+  (string)
+] @fold
+
+(comment)+ @fold
@@ -0,0 +1,160 @@
+; Identifiers
+
+((identifier) @type
+  (#match? @type "^[A-Z]"))
+
+((identifier) @constant
+  (#match? @constant "^[A-Z][A-Z_0-9]*$"))
+
+((identifier) @variable.builtin
+  (#match? @variable.builtin "^(self|cls)$"))
+
+(attribute
+  attribute: (identifier) @property)
+
+(keyword_argument
+  name: (identifier) @variable.parameter)
+
+(parameters
+  (identifier) @variable.parameter)
+
+(default_parameter
+  name: (identifier) @variable.parameter)
+
+(typed_parameter
+  (identifier) @variable.parameter)
+
+(typed_default_parameter
+  name: (identifier) @variable.parameter)
+
+; Functions
+
+(decorator) @attribute
+
+(call
+  function: (identifier) @function)
+
+(call
+  function: (attribute
+    attribute: (identifier) @function.method))
+
+((call
+  function: (identifier) @function.builtin)
+  (#match? @function.builtin "^(abs|all|any|bin|bool|bytes|callable|chr|dict|dir|divmod|enumerate|filter|float|format|getattr|hasattr|hash|hex|id|input|int|isinstance|issubclass|iter|len|list|map|max|min|next|object|oct|open|ord|pow|print|range|repr|reversed|round|set|setattr|sorted|str|sum|super|tuple|type|vars|zip)$"))
+
+(function_definition
+  name: (identifier) @function)
+
+(class_definition
+  name: (identifier) @type)
+
+(type
+  (identifier) @type)
+
+; Literals
+
+[
+  (none)
+  (true)
+  (false)
+] @constant.builtin
+
+[
+  (integer)
+  (float)
+] @number
+
+(comment) @comment
+(string) @string
+(escape_sequence) @escape
+
+(interpolation
+  "{" @punctuation.special
+  "}" @punctuation.special) @embedded
+
+; Tokens
+
+[
+  "-"
+  "-="
+  "!="
+  "*"
+  "**"
+  "**="
+  "*="
+  "/"
+  "//"
+  "//="
+  "/="
+  "&"
+  "%"
+  "%="
+  "^"
+  "+"
+  "+="
+  "<"
+  "<<"
+  "<="
+  "<>"
+  "="
+  ":="
+  "=="
+  ">"
+  ">="
+  ">>"
+  "|"
+  "~"
+  "->"
+] @operator
+
+[
+  "("
+  ")"
+  "["
+  "]"
+  "{"
+  "}"
+] @punctuation.bracket
+
+[
+  ","
+  "."
+  ":"
+] @punctuation.delimiter
+
+[
+  "and"
+  "as"
+  "assert"
+  "async"
+  "await"
+  "break"
+  "class"
+  "continue"
+  "def"
+  "del"
+  "elif"
+  "else"
+  "except"
+  "finally"
+  "for"
+  "from"
+  "global"
+  "if"
+  "import"
+  "in"
+  "is"
+  "lambda"
+  "nonlocal"
+  "not"
+  "or"
+  "pass"
+  "raise"
+  "return"
+  "try"
+  "while"
+  "with"
+  "yield"
+  "match"
+  "case"
+] @keyword
@@ -0,0 +1,36 @@
+[
+  (list)
+  (tuple)
+  (dictionary)
+  (set)
+  (parenthesized_expression)
+  (generator_expression)
+  (list_comprehension)
+  (dictionary_comprehension)
+  (set_comprehension)
+  (argument_list)
+  (parameters)
+  (block)
+] @indent
+
+[
+  (function_definition)
+  (class_definition)
+  (if_statement)
+  (elif_clause)
+  (else_clause)
+  (for_statement)
+  (while_statement)
+  (with_statement)
+  (try_statement)
+  (except_clause)
+  (finally_clause)
+  (match_statement)
+  (case_clause)
+] @extend
+
+[
+  "}"
+  ")"
+  "]"
+] @outdent
@@ -0,0 +1,21 @@
+(function_definition
+  body: (block)? @function.inside) @function.around
+
+(lambda
+  body: (_) @function.inside) @function.around
+
+(class_definition
+  body: (block)? @class.inside) @class.around
+
+(parameters
+  (_) @parameter.inside @parameter.around . ","? @parameter.around)
+
+(lambda_parameters
+  (_) @parameter.inside @parameter.around . ","? @parameter.around)
+
+(argument_list
+  (_) @parameter.inside @parameter.around . ","? @parameter.around)
+
+(comment) @comment.inside
+
+(comment)+ @comment.around
@@ -0,0 +1,17 @@
+[
+  (function_item)
+  (impl_item)
+  (trait_item)
+  (struct_item)
+  (enum_item)
+  (union_item)
+  (mod_item)
+  (macro_definition)
+  (block)
+  (match_block)
+  (use_declaration)
+] @fold
+
+(line_comment)+ @fold
+
+(block_comment) @fold
@@ -0,0 +1,22 @@
+[
+  (use_list)
+  (block)
+  (match_block)
+  (arguments)
+  (parameters)
+  (declaration_list)
+  (field_declaration_list)
+  (field_initializer_list)
+  (enum_variant_list)
+  (struct_pattern)
+  (tuple_expression)
+  (array_expression)
+  (token_tree)
+  (where_clause)
+] @indent
+
+[
+  "}"
+  ")"
+  "]"
+] @outdent
@@ -0,0 +1,335 @@
+// Package syntax contains the languages elmo knows about: their tree-sitter
+// grammars, their queries and the rules used to detect them.
+package syntax
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Ardelean-Calin/elmo/pkg/config"
+	"github.com/BurntSushi/toml"
+	sitter "github.com/smacker/go-tree-sitter"
+	"github.com/smacker/go-tree-sitter/bash"
+	"github.com/smacker/go-tree-sitter/c"
+	"github.com/smacker/go-tree-sitter/dockerfile"
+	"github.com/smacker/go-tree-sitter/golang"
+	"github.com/smacker/go-tree-sitter/nix"
+	"github.com/smacker/go-tree-sitter/python"
+	"github.com/smacker/go-tree-sitter/rust"
+	tstoml "github.com/smacker/go-tree-sitter/toml"
+	"github.com/smacker/go-tree-sitter/typescript/tsx"
+	"github.com/smacker/go-tree-sitter/typescript/typescript"
+	"github.com/smacker/go-tree-sitter/yaml"
+)
+
+//go:embed languages.toml
+var defaultLanguages []byte
+
+//go:embed */*.scm
+var queries embed.FS
+
+// grammars holds every tree-sitter grammar compiled into elmo. Unlike
+// queries, these can't be loaded at runtime.
+var grammars = map[string]func() *sitter.Language{
+	"go":         golang.GetLanguage,
+	"rust":       rust.GetLanguage,
+	"nix":        nix.GetLanguage,
+	"python":     python.GetLanguage,
+	"typescript": typescript.GetLanguage,
+	"tsx":        tsx.GetLanguage,
+	"yaml":       yaml.GetLanguage,
+	"bash":       bash.GetLanguage,
+	"c":          c.GetLanguage,
+	"dockerfile": dockerfile.GetLanguage,
+	"toml":       tstoml.GetLanguage,
+}
+
+// Language describes a single language inside languages.toml
+type Language struct {
+	Name      string   `toml:"name"`
+	Grammar   string   `toml:"grammar"`
+	Queries   string   `toml:"queries"`
+	FileTypes []string `toml:"file-types"`
+	Filenames []string `toml:"filenames"`
+	Globs     []string `toml:"globs"`
+	Shebangs  []string `toml:"shebangs"`
+	Indent    string   `toml:"indent"`
+	Pairs     []string `toml:"auto-pairs"`
+	// Token starting line comments, like "//"
+	CommentToken string `toml:"comment-token"`
+	// Used when there is no line comment token
+	BlockComment BlockComment `toml:"block-comment-tokens"`
+
+	runtime string // Directory searched for user queries
+}
+
+// BlockComment holds the tokens around block comments, like "/*" and "*/"
+type BlockComment struct {
+	Start string `toml:"start"`
+	End   string `toml:"end"`
+}
+
+// Registry is the list of known languages
+type Registry struct {
+	Languages []*Language `toml:"language"`
+}
+
+// Load reads the embedded language definitions and merges the user's
+// languages.toml on top of them. A language with the same name as an
+// embedded one only overrides the keys it sets, new languages take
+// precedence over the embedded ones during detection. The defaults are
+// returned even if the user's file is broken.
+func Load() (*Registry, error) {
+	return load(config.Dir())
+}
+
+func load(dir string) (*Registry, error) {
+	var r Registry
+	if _, err := toml.Decode(string(defaultLanguages), &r); err != nil {
+		panic(err)
+	}
+	runtime := filepath.Join(dir, "runtime")
+	for _, l := range r.Languages {
+		l.runtime = runtime
+	}
+
+	var user Registry
+	_, err := toml.DecodeFile(filepath.Join(dir, "languages.toml"), &user)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &r, nil
+	}
+	if err != nil {
+		return &r, err
+	}
+
+	var added []*Language
+	for _, l := range user.Languages {
+		if l.Name == "" {
+			return &r, errors.New("languages.toml: language without a name")
+		}
+		l.runtime = runtime
+		if existing := r.find(l.Name); existing != nil {
+			existing.merge(l)
+		} else {
+			added = append(added, l)
+		}
+	}
+	r.Languages = append(added, r.Languages...)
+
+	// Languages whose grammar isn't compiled in can't be parsed, so they
+	// are left out
+	var errs []error
+	r.Languages = slices.DeleteFunc(r.Languages, func(l *Language) bool {
+		if _, ok := grammars[l.grammar()]; !ok {
+			errs = append(errs, fmt.Errorf("languages.toml: %s: unknown grammar %q", l.Name, l.grammar()))
+			return true
+		}
+		return false
+	})
+	return &r, errors.Join(errs...)
+}
+
+// find returns the language with the given name
+func (r *Registry) find(name string) *Language {
+	for _, l := range r.Languages {
+		if l.Name == name {
+			return l
+		}
+	}
+	return nil
+}
+
+// merge overrides the keys of l set inside other
+func (l *Language) merge(other *Language) {
+	if other.Grammar != "" {
+		l.Grammar = other.Grammar
+	}
+	if other.Queries != "" {
+		l.Queries = other.Queries
+	}
+	if other.FileTypes != nil {
+		l.FileTypes = other.FileTypes
+	}
+	if other.Filenames != nil {
+		l.Filenames = other.Filenames
+	}
+	if other.Globs != nil {
+		l.Globs = other.Globs
+	}
+	if other.Shebangs != nil {
+		l.Shebangs = other.Shebangs
+	}
+	if other.Indent != "" {
+		l.Indent = other.Indent
+	}
+	if other.Pairs != nil {
+		l.Pairs = other.Pairs
+	}
+	if other.CommentToken != "" {
+		l.CommentToken = other.CommentToken
+	}
+	if other.BlockComment.Start != "" {
+		l.BlockComment = other.BlockComment
+	}
+}
+
+// IndentUnit returns the text inserted for one level of indentation
+func (l *Language) IndentUnit() string {
+	if l.Indent == "" {
+		return "\t"
+	}
+	return l.Indent
+}
+
+// DefaultAutoPairs are the auto-pairs of languages which don't set their
+// own, by opening character
+var DefaultAutoPairs = map[byte]byte{'(': ')', '[': ']', '{': '}', '"': '"', '\'': '\'', '`': '`'}
+
+// AutoPairs returns the characters closing the pairs typed together, by
+// opening character. Pairs which aren't two ASCII characters are ignored.
+func (l *Language) AutoPairs() map[byte]byte {
+	if l.Pairs == nil {
+		return DefaultAutoPairs
+	}
+	pairs := map[byte]byte{}
+	for _, p := range l.Pairs {
+		if len(p) == 2 && p[0] < utf8.RuneSelf && p[1] < utf8.RuneSelf {
+			pairs[p[0]] = p[1]
+		}
+	}
+	return pairs
+}
+
+// Detect returns the language of the file at path, or nil if unknown. Exact
+// file names win over globs, which win over extensions. The first line of
+// the content is only used for files with none of those, to look for a
+// shebang.
+func (r *Registry) Detect(path string, content []byte) *Language {
+	base := filepath.Base(path)
+	for _, l := range r.Languages {
+		for _, name := range l.Filenames {
+			if name == base {
+				return l
+			}
+		}
+	}
+	for _, l := range r.Languages {
+		for _, glob := range l.Globs {
+			if matchGlob(glob, path) {
+				return l
+			}
+		}
+	}
+	if ext := strings.TrimPrefix(filepath.Ext(path), "."); ext != "" {
+		for _, l := range r.Languages {
+			for _, ft := range l.FileTypes {
+				if ft == ext {
+					return l
+				}
+			}
+		}
+	}
+	if interpreter := shebang(content); interpreter != "" {
+		for _, l := range r.Languages {
+			for _, s := range l.Shebangs {
+				if s == interpreter {
+					return l
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Injected returns the language injected into another one under the given
+// name, like "bash", or its file type, like "sh". Returns nil if unknown.
+func (r *Registry) Injected(name string) *Language {
+	if l := r.find(name); l != nil {
+		return l
+	}
+	for _, l := range r.Languages {
+		if slices.Contains(l.FileTypes, name) {
+			return l
+		}
+	}
+	return nil
+}
+
+// matchGlob matches the pattern against as many trailing path elements as it
+// has, so "*.yml" matches any YAML file and ".github/workflows/*.yml" only
+// the ones inside that directory.
+func matchGlob(pattern, file string) bool {
+	elems := strings.Split(filepath.ToSlash(file), "/")
+	n := strings.Count(pattern, "/") + 1
+	if n > len(elems) {
+		return false
+	}
+	ok, _ := path.Match(pattern, strings.Join(elems[len(elems)-n:], "/"))
+	return ok
+}
+
+// shebang returns the name of the interpreter inside a "#!" line, skipping
+// env and its flags
+func shebang(content []byte) string {
+	line, _, _ := bytes.Cut(content, []byte{'\n'})
+	line, ok := bytes.CutPrefix(line, []byte("#!"))
+	if !ok {
+		return ""
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) > 0 && path.Base(fields[0]) == "env" {
+		fields = fields[1:]
+		for len(fields) > 0 && (strings.HasPrefix(fields[0], "-") || strings.Contains(fields[0], "=")) {
+			fields = fields[1:]
+		}
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+	return path.Base(fields[0])
+}
+
+func (l *Language) grammar() string {
+	if l.Grammar != "" {
+		return l.Grammar
+	}
+	return l.Name
+}
+
+func (l *Language) queryDir() string {
+	if l.Queries != "" {
+		return l.Queries
+	}
+	return l.Name
+}
+
+// SitterLanguage returns the tree-sitter grammar of the language
+func (l *Language) SitterLanguage() *sitter.Language {
+	if grammar, ok := grammars[l.grammar()]; ok {
+		return grammar()
+	}
+	return nil
+}
+
+// Query returns the content of the given query file, like "highlights". A
+// file inside the user's runtime directory replaces the embedded one. The
+// error wraps fs.ErrNotExist if the language has no such query.
+func (l *Language) Query(name string) ([]byte, error) {
+	file := path.Join(l.queryDir(), name+".scm")
+	if l.runtime != "" {
+		content, err := os.ReadFile(filepath.Join(l.runtime, "queries", filepath.FromSlash(file)))
+		if !errors.Is(err, fs.ErrNotExist) {
+			return content, err
+		}
+	}
+	return queries.ReadFile(file)
+}
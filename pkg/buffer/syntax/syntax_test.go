@@ -0,0 +1,172 @@
+package syntax
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// name returns the name of the detected language, or "" if none
+func name(l *Language) string {
+	if l == nil {
+		return ""
+	}
+	return l.Name
+}
+
+func TestDefaults(t *testing.T) {
+	is := is.New(t)
+
+	r, err := load(t.TempDir())
+	is.NoErr(err)
+	for _, l := range r.Languages {
+		t.Run(l.Name, func(t *testing.T) {
+			is := is.New(t)
+			is.True(l.SitterLanguage() != nil) // every grammar is compiled in
+			for _, name := range []string{"highlights", "textobjects", "indents", "injections", "folds"} {
+				query, err := l.Query(name)
+				if name != "highlights" && errors.Is(err, fs.ErrNotExist) {
+					continue // only highlights are required
+				}
+				is.NoErr(err)
+				_, err = sitter.NewQuery(query, l.SitterLanguage())
+				is.NoErr(err) // embedded queries must compile
+			}
+		})
+	}
+}
+
+func TestDetectDefaults(t *testing.T) {
+	is := is.New(t)
+
+	r, err := load(t.TempDir())
+	is.NoErr(err)
+	for file, want := range map[string]string{
+		"main.go":                         "go",
+		"lib.rs":                          "rust",
+		"flake.nix":                       "nix",
+		"app.py":                          "python",
+		"index.ts":                        "typescript",
+		"App.tsx":                         "tsx",
+		".github/workflows/ci.yaml":       "yaml",
+		"deploy.sh":                       "bash",
+		"/home/user/.bashrc":              "bash",
+		"main.c":                          "c",
+		"include/list.h":                  "c",
+		"Dockerfile":                      "dockerfile",
+		"Dockerfile.dev":                  "dockerfile",
+		"pyproject.toml":                  "toml",
+		"Cargo.lock":                      "toml",
+		"/usr/share/doc/package/notes.md": "",
+	} {
+		is.Equal(name(r.Detect(file, nil)), want) // detected language
+	}
+}
+
+func TestDetect(t *testing.T) {
+	is := is.New(t)
+
+	r := &Registry{Languages: []*Language{
+		{Name: "make", Filenames: []string{"Makefile"}, FileTypes: []string{"mk"}},
+		{Name: "workflow", Globs: []string{".github/workflows/*.yml"}},
+		{Name: "yaml", FileTypes: []string{"yml", "yaml"}},
+		{Name: "python", FileTypes: []string{"py"}, Shebangs: []string{"python3"}},
+		{Name: "bash", Shebangs: []string{"bash", "sh"}},
+	}}
+
+	is.Equal(name(r.Detect("/src/Makefile", nil)), "make")
+	is.Equal(name(r.Detect("rules.mk", nil)), "make")
+	is.Equal(name(r.Detect("/repo/.github/workflows/ci.yml", nil)), "workflow")
+	is.Equal(name(r.Detect("/repo/workflows/ci.yml", nil)), "yaml")
+	is.Equal(name(r.Detect("ci.yml", nil)), "yaml")
+	is.Equal(name(r.Detect("script", []byte("#!/usr/bin/env python3\nprint()"))), "python")
+	is.Equal(name(r.Detect("script", []byte("#!/usr/bin/env -S bash -e\n"))), "bash")
+	is.Equal(name(r.Detect("script", []byte("#!/bin/sh"))), "bash")
+	is.Equal(name(r.Detect("script", []byte("# not a shebang"))), "")
+	is.Equal(name(r.Detect("notes.txt", nil)), "")
+	// The extension wins over the shebang
+	is.Equal(name(r.Detect("main.py", []byte("#!/bin/bash\n"))), "python")
+}
+
+func TestUserOverrides(t *testing.T) {
+	is := is.New(t)
+
+	dir := t.TempDir()
+	is.NoErr(os.WriteFile(filepath.Join(dir, "languages.toml"), []byte(`
+[[language]]
+name = "go"
+file-types = ["go", "gotmpl"]
+indent = "  "
+auto-pairs = ["()", "<>", "«»"]
+
+[[language]]
+name = "gomod"
+grammar = "go"
+queries = "go"
+filenames = ["go.mod"]
+`), 0644))
+	queries := filepath.Join(dir, "runtime", "queries", "go")
+	is.NoErr(os.MkdirAll(queries, 0755))
+	is.NoErr(os.WriteFile(filepath.Join(queries, "highlights.scm"), []byte("(comment) @comment"), 0644))
+
+	r, err := load(dir)
+	is.NoErr(err)
+	is.Equal(name(r.Detect("page.gotmpl", nil)), "go")
+	is.Equal(name(r.Detect("main.rs", nil)), "rust") // untouched defaults still work
+	gomod := r.Detect("/src/go.mod", nil)
+	is.Equal(name(gomod), "gomod")
+	is.Equal(r.Detect("main.go", nil).IndentUnit(), "  ")
+	is.Equal(gomod.IndentUnit(), "\t")
+	is.Equal(r.Detect("main.rs", nil).IndentUnit(), "    ")
+	is.Equal(r.Detect("main.go", nil).AutoPairs(), map[byte]byte{'(': ')', '<': '>'})
+	is.Equal(gomod.AutoPairs(), DefaultAutoPairs)
+	is.Equal(r.Detect("main.rs", nil).AutoPairs()['\''], byte(0))
+	is.Equal(r.Detect("main.go", nil).CommentToken, "//")
+	is.Equal(r.Detect("main.go", nil).BlockComment, BlockComment{Start: "/*", End: "*/"})
+
+	// Both languages read the overridden query
+	for _, l := range []*Language{r.Detect("main.go", nil), gomod} {
+		highlights, err := l.Query("highlights")
+		is.NoErr(err)
+		is.Equal(string(highlights), "(comment) @comment")
+	}
+	// Files which are not overridden come from the embedded defaults
+	highlights, err := r.Detect("main.rs", nil).Query("highlights")
+	is.NoErr(err)
+	is.True(len(highlights) > 0)
+	_, err = r.Detect("main.rs", nil).Query("missing")
+	is.True(errors.Is(err, fs.ErrNotExist))
+}
+
+func TestUnknownGrammar(t *testing.T) {
+	is := is.New(t)
+
+	dir := t.TempDir()
+	is.NoErr(os.WriteFile(filepath.Join(dir, "languages.toml"), []byte(`
+[[language]]
+name = "cobol"
+file-types = ["cbl"]
+`), 0644))
+
+	r, err := load(dir)
+	is.True(err != nil)
+	is.Equal(name(r.Detect("main.go", nil)), "go") // the defaults are still usable
+	is.Equal(r.Detect("main.cbl", nil), nil)       // it can't be parsed
+	is.Equal(r.find("cobol"), nil)
+}
+
+func TestInjected(t *testing.T) {
+	is := is.New(t)
+
+	r, err := load(t.TempDir())
+	is.NoErr(err)
+	is.Equal(name(r.Injected("bash")), "bash")
+	is.Equal(name(r.Injected("sh")), "bash") // by file type
+	is.Equal(name(r.Injected("py")), "python")
+	is.Equal(name(r.Injected("sql")), "")
+}
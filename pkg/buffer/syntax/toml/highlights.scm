@@ -0,0 +1,49 @@
+(bare_key) @variable.member
+(quoted_key) @string
+
+(boolean) @constant.builtin
+(comment) @comment
+(string) @string
+(escape_sequence) @escape
+
+[
+  (integer)
+  (float)
+] @number
+
+[
+  (offset_date_time)
+  (local_date_time)
+  (local_date)
+  (local_time)
+] @string.special
+
+[
+  "."
+  ","
+] @punctuation.delimiter
+
+"=" @operator
+
+[
+  "["
+  "]"
+  "[["
+  "]]"
+  "{"
+  "}"
+] @punctuation.bracket
+
+(table
+  [
+    (bare_key)
+    (dotted_key)
+    (quoted_key)
+  ] @type)
+
+(table_array_element
+  [
+    (bare_key)
+    (dotted_key)
+    (quoted_key)
+  ] @type)
@@ -0,0 +1,13 @@
+[
+  (function_declaration)
+  (method_definition)
+  (class_declaration)
+  (interface_declaration)
+  (enum_declaration)
+  (statement_block)
+  (object)
+  (array)
+  (import_statement)
+] @fold
+
+(comment)+ @fold
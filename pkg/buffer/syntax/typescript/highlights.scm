@@ -0,0 +1,205 @@
+; Identifiers
+
+(identifier) @variable
+
+((identifier) @type
+  (#match? @type "^[A-Z]"))
+
+((identifier) @constant
+  (#match? @constant "^[A-Z][A-Z_0-9]*$"))
+
+(this) @variable.builtin
+(super) @variable.builtin
+
+(property_identifier) @property
+(shorthand_property_identifier) @property
+(private_property_identifier) @property
+
+(required_parameter
+  pattern: (identifier) @variable.parameter)
+
+(optional_parameter
+  pattern: (identifier) @variable.parameter)
+
+(arrow_function
+  parameter: (identifier) @variable.parameter)
+
+; Types
+
+(type_identifier) @type
+(predefined_type) @type.builtin
+
+(type_parameter
+  name: (type_identifier) @type)
+
+; Functions
+
+(function_declaration
+  name: (identifier) @function)
+
+(function
+  name: (identifier) @function)
+
+(method_definition
+  name: (property_identifier) @function.method)
+
+(variable_declarator
+  name: (identifier) @function
+  value: [(function) (arrow_function)])
+
+(call_expression
+  function: (identifier) @function)
+
+(call_expression
+  function: (member_expression
+    property: (property_identifier) @function.method))
+
+(new_expression
+  constructor: (identifier) @constructor)
+
+(decorator) @attribute
+
+; Literals
+
+[
+  (true)
+  (false)
+  (null)
+  (undefined)
+] @constant.builtin
+
+(number) @number
+(comment) @comment
+
+[
+  (string)
+  (template_string)
+] @string
+
+(regex) @string
+(escape_sequence) @escape
+
+(template_substitution
+  "${" @punctuation.special
+  "}" @punctuation.special) @embedded
+
+; Tokens
+
+[
+  "-"
+  "--"
+  "-="
+  "+"
+  "++"
+  "+="
+  "*"
+  "*="
+  "**"
+  "**="
+  "/"
+  "/="
+  "%"
+  "%="
+  "<"
+  "<="
+  "<<"
+  "<<="
+  "="
+  "=="
+  "==="
+  "!"
+  "!="
+  "!=="
+  "=>"
+  ">"
+  ">="
+  ">>"
+  ">>="
+  ">>>"
+  ">>>="
+  "~"
+  "^"
+  "&"
+  "|"
+  "^="
+  "&="
+  "|="
+  "&&"
+  "||"
+  "??"
+  "&&="
+  "||="
+  "??="
+  "?."
+] @operator
+
+[
+  "("
+  ")"
+  "["
+  "]"
+  "{"
+  "}"
+] @punctuation.bracket
+
+[
+  ";"
+  "."
+  ","
+  ":"
+] @punctuation.delimiter
+
+[
+  "abstract"
+  "as"
+  "async"
+  "await"
+  "break"
+  "case"
+  "catch"
+  "class"
+  "const"
+  "continue"
+  "debugger"
+  "declare"
+  "default"
+  "delete"
+  "do"
+  "else"
+  "enum"
+  "export"
+  "extends"
+  "finally"
+  "for"
+  "from"
+  "function"
+  "get"
+  "if"
+  "implements"
+  "import"
+  "in"
+  "instanceof"
+  "interface"
+  "keyof"
+  "let"
+  "namespace"
+  "new"
+  "of"
+  "private"
+  "protected"
+  "public"
+  "readonly"
+  "return"
+  "set"
+  "static"
+  "switch"
+  "throw"
+  "try"
+  "type"
+  "typeof"
+  "var"
+  "void"
+  "while"
+  "with"
+  "yield"
+] @keyword
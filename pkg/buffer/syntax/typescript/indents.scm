@@ -0,0 +1,21 @@
+[
+  (array)
+  (object)
+  (arguments)
+  (formal_parameters)
+  (statement_block)
+  (class_body)
+  (object_type)
+  (enum_body)
+  (switch_body)
+  (switch_case)
+  (switch_default)
+  (named_imports)
+  (template_string)
+] @indent
+
+[
+  "}"
+  ")"
+  "]"
+] @outdent
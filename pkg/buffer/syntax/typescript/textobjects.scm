@@ -0,0 +1,39 @@
+(function_declaration
+  body: (_) @function.inside) @function.around
+
+(function
+  body: (_) @function.inside) @function.around
+
+(arrow_function
+  body: (_) @function.inside) @function.around
+
+(method_definition
+  body: (_) @function.inside) @function.around
+
+(generator_function_declaration
+  body: (_) @function.inside) @function.around
+
+(class_declaration
+  body: (class_body) @class.inside) @class.around
+
+(class
+  body: (class_body) @class.inside) @class.around
+
+(interface_declaration
+  body: (_) @class.inside) @class.around
+
+(enum_declaration
+  body: (_) @class.inside) @class.around
+
+(formal_parameters
+  (_) @parameter.inside @parameter.around . ","? @parameter.around)
+
+(type_parameters
+  (_) @parameter.inside @parameter.around . ","? @parameter.around)
+
+(arguments
+  (_) @parameter.inside @parameter.around . ","? @parameter.around)
+
+(comment) @comment.inside
+
+(comment)+ @comment.around
@@ -0,0 +1,62 @@
+(block_mapping_pair
+  key: (flow_node) @variable.member)
+
+(flow_mapping
+  (_
+    key: (flow_node) @variable.member))
+
+[
+  (double_quote_scalar)
+  (single_quote_scalar)
+  (block_scalar)
+] @string
+
+(escape_sequence) @escape
+
+[
+  (integer_scalar)
+  (float_scalar)
+] @number
+
+[
+  (boolean_scalar)
+  (null_scalar)
+] @constant.builtin
+
+(comment) @comment
+
+[
+  (anchor_name)
+  (alias_name)
+] @label
+
+(tag) @type
+
+[
+  (yaml_directive)
+  (tag_directive)
+  (reserved_directive)
+] @attribute
+
+[
+  ","
+  "-"
+  ":"
+  ">"
+  "?"
+  "|"
+] @punctuation.delimiter
+
+[
+  "["
+  "]"
+  "{"
+  "}"
+] @punctuation.bracket
+
+[
+  "*"
+  "&"
+  "---"
+  "..."
+] @punctuation.special
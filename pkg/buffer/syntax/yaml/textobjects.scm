@@ -0,0 +1,3 @@
+(comment) @comment.inside
+
+(comment)+ @comment.around
@@ -0,0 +1,111 @@
+package buffer
+
+import (
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// Text objects are the captures of the textobjects.scm queries, like
+// "function.inside" or "parameter.around"
+
+// textRange is the range of characters [start, end)
+type textRange struct {
+	start, end int
+}
+
+// textObjects returns the ranges of every text object with the given
+// capture name
+func (s *SourceCode) textObjects(name string) []textRange {
+	return s.captureRanges(s.freshTree(), s.queries.textobjects, name)
+}
+
+// captureRanges returns the ranges captured by query with the given name.
+// Captures with the same name in one match, like a parameter and its comma,
+// are joined into one range.
+func (s *SourceCode) captureRanges(root *sitter.Node, query *sitter.Query, name string) []textRange {
+	if root == nil || query == nil {
+		return nil
+	}
+
+	qc := sitter.NewQueryCursor()
+	defer qc.Close()
+	qc.Exec(query, root)
+	var ranges []textRange
+	for {
+		m, ok := qc.NextMatch()
+		if !ok {
+			break
+		}
+		if !matchesPredicates(query, m, s.data) {
+			continue
+		}
+
+		found := false
+		var r textRange
+		for _, c := range m.Captures {
+			if query.CaptureNameForId(c.Index) != name || c.Node.StartByte() == c.Node.EndByte() {
+				continue
+			}
+			start, end := int(c.Node.StartByte()), int(c.Node.EndByte())
+			if !found {
+				r = textRange{start, end}
+				found = true
+			}
+			r.start, r.end = min(r.start, start), max(r.end, end)
+		}
+		if found {
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges
+}
+
+// selectRange selects [start, end), with the cursor on the last character
+func (s *SourceCode) selectRange(r textRange) {
+	s.setSelection(selection{anchor: r.start, end: r.end - 1, cursor: r.end - 1})
+}
+
+// SelectTextObject selects the smallest text object around the cursor.
+// object is the name of the object, like "function". With around, the
+// whole object gets selected, otherwise only its inside.
+func (s *SourceCode) SelectTextObject(object string, around bool) {
+	name := object + ".inside"
+	if around {
+		name = object + ".around"
+	}
+
+	var best textRange
+	found := false
+	for _, r := range s.textObjects(name) {
+		if r.start <= s.cursor && s.cursor < r.end && (!found || r.end-r.start < best.end-best.start) {
+			best = r
+			found = true
+		}
+	}
+	if found {
+		s.selectRange(best)
+	}
+}
+
+// GotoTextObject selects the next text object after the cursor, or the
+// previous one before the selection
+func (s *SourceCode) GotoTextObject(object string, forward bool) {
+	start, _ := s.GetSelection()
+
+	var best textRange
+	found := false
+	for _, r := range s.textObjects(object + ".around") {
+		var better bool
+		if forward {
+			better = r.start > s.cursor && (!found || r.start < best.start)
+		} else {
+			better = r.start < start && (!found || r.start > best.start)
+		}
+		if better {
+			best = r
+			found = true
+		}
+	}
+	if found {
+		s.selectRange(best)
+	}
+}
@@ -0,0 +1,206 @@
+package buffer
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Commands transforming the selected text: changing its case, replacing
+// its characters and incrementing the number under the cursor
+
+// replaceRange replaces the text of r and selects the new text. The
+// selection stays as it is if the length doesn't change.
+func (s *SourceCode) replaceRange(r textRange, text string) {
+	if text == string(s.data.Slice(r.start, r.end)) {
+		return
+	}
+	sel := s.selection()
+	s.deleteAt(r.start, r.end)
+	s.insertAt(r.start, []byte(text))
+	if len(text) == r.end-r.start {
+		s.setSelection(sel)
+	} else if text != "" {
+		s.selectRange(textRange{r.start, r.start + len(text)})
+	} else {
+		s.SetCursor(r.start)
+	}
+}
+
+// mapSelection replaces every character of the selection by the result of
+// f, line breaks excluded
+func (s *SourceCode) mapSelection(f func(rune) rune) {
+	for _, r := range s.selections() {
+		text := strings.Map(func(c rune) rune {
+			if c == '\n' || c == '\r' {
+				return c
+			}
+			return f(c)
+		}, string(s.data.Slice(r.start, r.end)))
+		s.replaceRange(r, text)
+	}
+}
+
+// switchCase turns lower case into upper case and the other way around
+func switchCase(c rune) rune {
+	if unicode.IsUpper(c) {
+		return unicode.ToLower(c)
+	}
+	return unicode.ToUpper(c)
+}
+
+// SwitchCase switches the case of the selected text
+func (s *SourceCode) SwitchCase() {
+	s.mapSelection(switchCase)
+}
+
+// Lowercase turns the selected text into lower case
+func (s *SourceCode) Lowercase() {
+	s.mapSelection(unicode.ToLower)
+}
+
+// Uppercase turns the selected text into upper case
+func (s *SourceCode) Uppercase() {
+	s.mapSelection(unicode.ToUpper)
+}
+
+// ReplaceChars replaces every selected character by c
+func (s *SourceCode) ReplaceChars(c rune) {
+	s.mapSelection(func(rune) rune { return c })
+}
+
+var (
+	// Dates like 2024-02-29
+	datePattern = regexp.MustCompile(`\d{4}-\d{2}-\d{2}`)
+	// Booleans, in the usual spellings
+	boolPattern = regexp.MustCompile(`\b(true|false|True|False|TRUE|FALSE)\b`)
+	// Integers, which may be hexadecimal, binary or octal. A minus right
+	// after a word, like in x-1, is a subtraction rather than a sign.
+	numberPattern = regexp.MustCompile(`\b(0[xX][0-9a-fA-F]+|0[bB][01]+|0[oO][0-7]+)\b|(\B-)?\b\d+\b`)
+)
+
+// booleans maps every boolean to its opposite
+var booleans = map[string]string{
+	"true": "false", "false": "true",
+	"True": "False", "False": "True",
+	"TRUE": "FALSE", "FALSE": "TRUE",
+}
+
+// Increment adds n to the number under the cursor, or to the first one after
+// it on its line. Dates get n added to their year, month or day, whichever
+// is under the cursor, and booleans get flipped.
+func (s *SourceCode) Increment(n int) {
+	_, line, _ := s.CurrentLine()
+	text := string(s.data.Slice(line.start, line.end))
+	col := s.cursor - line.start
+
+	var best []int
+	var change func(match string, at int) (string, bool)
+	// Dates contain numbers, so they go first
+	for _, kind := range []struct {
+		pattern *regexp.Regexp
+		change  func(match string, at int) (string, bool)
+	}{
+		{datePattern, func(date string, at int) (string, bool) { return incrementDate(date, at, n) }},
+		{boolPattern, func(b string, _ int) (string, bool) { return booleans[b], true }},
+		{numberPattern, func(number string, _ int) (string, bool) { return incrementNumber(number, n) }},
+	} {
+		loc := findAround(kind.pattern, text, col)
+		// The one under the cursor, or else the closest one
+		if loc != nil && (best == nil || best[0] > col && loc[0] < best[0]) {
+			best, change = loc, kind.change
+		}
+	}
+	if best == nil {
+		return
+	}
+
+	// Dates after the cursor get their day changed
+	at := col - best[0]
+	if at < 0 {
+		at = best[1] - best[0] - 1
+	}
+	if changed, ok := change(text[best[0]:best[1]], at); ok {
+		start := line.start + best[0]
+		s.replaceRange(textRange{start, line.start + best[1]}, changed)
+		s.selectRange(textRange{start, start + len(changed)})
+	}
+}
+
+// findAround returns the match of pattern in text around col, or else the
+// first one after it
+func findAround(pattern *regexp.Regexp, text string, col int) []int {
+	for _, loc := range pattern.FindAllStringIndex(text, -1) {
+		if loc[1] > col {
+			return loc
+		}
+	}
+	return nil
+}
+
+// incrementNumber adds n to an integer literal, keeping its base, the case
+// of its digits and its leading zeros
+func incrementNumber(number string, n int) (string, bool) {
+	prefix, digits, base := "", number, 10
+	if len(number) > 2 && number[0] == '0' {
+		switch number[1] {
+		case 'x', 'X':
+			prefix, digits, base = number[:2], number[2:], 16
+		case 'b', 'B':
+			prefix, digits, base = number[:2], number[2:], 2
+		case 'o', 'O':
+			prefix, digits, base = number[:2], number[2:], 8
+		}
+	}
+	value, err := strconv.ParseInt(digits, base, 64)
+	if err != nil {
+		return "", false
+	}
+	value += int64(n)
+	if prefix != "" && value < 0 {
+		return "", false
+	}
+
+	result := strconv.FormatInt(max(value, -value), base)
+	// Keep the width of numbers with leading zeros, and of hexadecimal,
+	// binary and octal ones
+	width := len(strings.TrimPrefix(digits, "-"))
+	if prefix != "" || width > 1 && strings.TrimPrefix(digits, "-")[0] == '0' {
+		result = strings.Repeat("0", max(0, width-len(result))) + result
+	}
+	if prefix != "" && strings.ToUpper(digits) == digits {
+		result = strings.ToUpper(result)
+	}
+	if value < 0 {
+		result = "-" + result
+	}
+	return prefix + result, true
+}
+
+// incrementDate adds n years, months or days to a date, depending on which
+// one is at col
+func incrementDate(date string, col, n int) (string, bool) {
+	t, err := time.Parse(time.DateOnly, date)
+	if err != nil {
+		return "", false
+	}
+	switch {
+	case col < 4:
+		t = addMonths(t, 12*n)
+	case col < 7:
+		t = addMonths(t, n)
+	default:
+		t = t.AddDate(0, 0, n)
+	}
+	return t.Format(time.DateOnly), true
+}
+
+// addMonths adds n months to t, keeping the day inside the new month: a
+// month after January 31st is the end of February
+func addMonths(t time.Time, n int) time.Time {
+	first := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, n, 0)
+	last := first.AddDate(0, 1, -1).Day()
+	return first.AddDate(0, 0, min(t.Day(), last)-1)
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Config is the user configuration, loaded from config.toml inside Dir()
+type Config struct {
+	Editor Editor `toml:"editor"`
+	// Macros replayed with q, by register, written in the key notation of
+	// Helix like "ms(<esc>". See buffer.keyNotation.
+	Macros map[string]string `toml:"macros"`
+}
+
+// Editor contains the [editor] section of the config
+type Editor struct {
+	// Files larger than this (in bytes) are opened in large file mode:
+	// memory mapped and without syntax highlighting.
+	LargeFileThreshold int64 `toml:"large-file-threshold"`
+	// Name of the base16 theme whose colors are used, as in themes.json.
+	// Empty means the palette of the theme.toml.
+	Theme string `toml:"theme"`
+}
+
+// Default returns the configuration used when no config file exists
+func Default() Config {
+	return Config{
+		Editor: Editor{
+			LargeFileThreshold: 64 << 20,
+		},
+	}
+}
+
+// Dir returns the elmo configuration directory, usually ~/.config/elmo
+func Dir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	return filepath.Join(dir, "elmo")
+}
+
+// Load reads the user configuration. Missing values keep their defaults.
+func Load() (Config, error) {
+	cfg := Default()
+	_, err := toml.DecodeFile(filepath.Join(Dir(), "config.toml"), &cfg)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	return cfg, err
+}
+
+// Set changes a single value inside config.toml, like the theme picked with
+// :theme. The rest of the file is kept as it is, comments included.
+func Set(section, key string, value any) error {
+	path := filepath.Join(Dir(), "config.toml")
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	var line bytes.Buffer
+	if err := toml.NewEncoder(&line).Encode(map[string]any{key: value}); err != nil {
+		return err
+	}
+	content = setLine(content, section, key, strings.TrimSpace(line.String()))
+
+	// Never write a file we can't read back
+	var cfg Config
+	if _, err := toml.Decode(string(content), &cfg); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(Dir(), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0644)
+}
+
+// setLine replaces the line assigning key inside section with line. If there
+// is no such line, it's added at the start of the section, which is created
+// if needed.
+func setLine(content []byte, section, key, line string) []byte {
+	lines := strings.Split(string(content), "\n")
+	header := -1
+	current := ""
+	for i, l := range lines {
+		l = strings.TrimSpace(l)
+		if strings.HasPrefix(l, "[") {
+			end := strings.IndexByte(l, ']')
+			current = strings.TrimSpace(strings.Trim(l[:max(end, 0)], "["))
+			if current == section {
+				header = i
+			}
+			continue
+		}
+		name, _, ok := strings.Cut(l, "=")
+		if ok && current == section && strings.Trim(strings.TrimSpace(name), `"'`) == key {
+			lines[i] = line
+			return []byte(strings.Join(lines, "\n"))
+		}
+	}
+
+	if header >= 0 {
+		lines = append(lines[:header+1], append([]string{line}, lines[header+1:]...)...)
+		return []byte(strings.Join(lines, "\n"))
+	}
+	text := strings.TrimRight(string(content), "\n")
+	if text != "" {
+		text += "\n\n"
+	}
+	return []byte(text + "[" + section + "]\n" + line + "\n")
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestSetLine(t *testing.T) {
+	is := is.New(t)
+
+	for _, tc := range []struct {
+		content, want string
+	}{
+		{"", "[editor]\ntheme = \"x\"\n"},
+		{"# My config\n", "# My config\n\n[editor]\ntheme = \"x\"\n"},
+		{
+			"[editor]\nlarge-file-threshold = 10 # bytes\n",
+			"[editor]\ntheme = \"x\"\nlarge-file-threshold = 10 # bytes\n",
+		},
+		{
+			"[editor] # comment\ntheme = \"old\" # mine\n\n[other]\ntheme = 1\n",
+			"[editor] # comment\ntheme = \"x\"\n\n[other]\ntheme = 1\n",
+		},
+		{
+			"[other]\ntheme = 1\n",
+			"[other]\ntheme = 1\n\n[editor]\ntheme = \"x\"\n",
+		},
+	} {
+		is.Equal(string(setLine([]byte(tc.content), "editor", "theme", `theme = "x"`)), tc.want)
+	}
+}
+
+func TestSet(t *testing.T) {
+	is := is.New(t)
+
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	is.NoErr(Set("editor", "theme", "gruvbox-dark-hard"))
+	cfg, err := Load()
+	is.NoErr(err)
+	is.Equal(cfg.Editor.Theme, "gruvbox-dark-hard")
+	is.Equal(cfg.Editor.LargeFileThreshold, Default().Editor.LargeFileThreshold)
+
+	is.NoErr(Set("editor", "large-file-threshold", 1024))
+	is.NoErr(Set("editor", "theme", `quo"ted`))
+	cfg, err = Load()
+	is.NoErr(err)
+	is.Equal(cfg.Editor.Theme, `quo"ted`)
+	is.Equal(cfg.Editor.LargeFileThreshold, int64(1024))
+
+	content, err := os.ReadFile(filepath.Join(Dir(), "config.toml"))
+	is.NoErr(err)
+	is.Equal(string(content), "[editor]\nlarge-file-threshold = 1024\ntheme = \"quo\\\"ted\"\n")
+}
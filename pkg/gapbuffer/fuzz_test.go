@@ -0,0 +1,168 @@
+package gapbuffer
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/Ardelean-Calin/elmo/pkg/storage"
+)
+
+// model is the reference implementation of a gap buffer: a plain slice and
+// a cursor.
+type model struct {
+	content []byte
+	cursor  int
+}
+
+func (m *model) insert(text []byte) {
+	m.content = append(m.content[:m.cursor:m.cursor], append(bytes.Clone(text), m.content[m.cursor:]...)...)
+	m.cursor += len(text)
+}
+
+func (m *model) delete(n int) {
+	end := min(m.cursor+max(n, 0), len(m.content))
+	m.content = append(m.content[:m.cursor:m.cursor], m.content[end:]...)
+}
+
+// snapshot is a snapshot taken during a test, along with what it must hold
+type snapshot struct {
+	text storage.Text
+	want []byte
+}
+
+// runOps interprets ops as a sequence of (operation, argument) pairs and
+// applies them to both a GapBuffer and the model, comparing them after
+// every step.
+func runOps(t *testing.T, content, ops []byte) {
+	gb := NewGapBuffer()
+	gb.SetContent(bytes.Clone(content))
+	m := model{content: bytes.Clone(content)}
+	var snapshots []snapshot
+
+	for i := 0; i+1 < len(ops); i += 2 {
+		op, arg := ops[i]%10, ops[i+1]
+		switch op {
+		case 0:
+			gb.Insert(arg)
+			m.insert([]byte{arg})
+		case 1:
+			text := bytes.Repeat([]byte{arg}, int(arg%7))
+			gb.InsertSlice(text)
+			m.insert(text)
+		case 2:
+			gb.Delete()
+			m.delete(1)
+		case 3:
+			gb.Backspace()
+			if m.cursor > 0 {
+				m.cursor--
+				m.delete(1)
+			}
+		case 4:
+			gb.DeleteRange(int(arg % 9))
+			m.delete(int(arg % 9))
+		case 5:
+			gb.CursorLeft()
+			m.cursor = max(m.cursor-1, 0)
+		case 6:
+			gb.CursorRight()
+			m.cursor = min(m.cursor+1, len(m.content))
+		case 7:
+			pos := int(arg) - 10
+			gb.CursorGoto(pos)
+			m.cursor = max(0, min(pos, len(m.content)))
+		case 8:
+			snapshots = append(snapshots, snapshot{gb.Snapshot(), bytes.Clone(m.content)})
+		case 9:
+			pos := int(arg) % (len(m.content) + 1)
+			gb.InsertAt(pos, []byte{arg})
+			m.cursor = pos
+			m.insert([]byte{arg})
+		}
+
+		if !check(t, &gb, &m) {
+			t.Fatalf("step %d (op %d, arg %d): got %q (cursor %d), want %q (cursor %d)",
+				i/2, op, arg, gb.Bytes(), gb.Cursor(), m.content, m.cursor)
+		}
+	}
+
+	for i, s := range snapshots {
+		if got := s.text.Bytes(); !bytes.Equal(got, s.want) {
+			t.Fatalf("snapshot %d: got %q, want %q", i, got, s.want)
+		}
+	}
+}
+
+// check compares the gap buffer against the model
+func check(t *testing.T, gb *GapBuffer, m *model) bool {
+	t.Helper()
+	if gb.GapStart < 0 || gb.GapStart > gb.GapEnd || gb.GapEnd > len(gb.Buffer) {
+		t.Errorf("invalid gap [%d, %d) in buffer of length %d", gb.GapStart, gb.GapEnd, len(gb.Buffer))
+		return false
+	}
+	if !bytes.Equal(gb.Bytes(), m.content) || gb.Len() != len(m.content) || gb.Cursor() != m.cursor {
+		return false
+	}
+	for i, c := range m.content {
+		if gb.GetAbs(i) != c {
+			t.Errorf("GetAbs(%d) = %q, want %q", i, gb.GetAbs(i), c)
+			return false
+		}
+	}
+	if m.cursor < len(m.content) && gb.Current() != m.content[m.cursor] {
+		t.Errorf("Current() = %q, want %q", gb.Current(), m.content[m.cursor])
+		return false
+	}
+	if m.cursor+1 < len(m.content) && gb.Next() != m.content[m.cursor+1] {
+		t.Errorf("Next() = %q, want %q", gb.Next(), m.content[m.cursor+1])
+		return false
+	}
+	return true
+}
+
+func FuzzOperations(f *testing.F) {
+	f.Add([]byte("Hello"), []byte{6, 0, 6, 0, 0, ',', 2, 0, 3, 0})
+	f.Add([]byte{}, []byte{1, 'a', 7, 2, 0, 'b', 8, 0, 3, 0, 4, 3})
+	f.Add([]byte("a\nb\nc"), []byte{7, 30, 5, 0, 1, 'x', 7, 11, 2, 0, 8, 0, 9, 4})
+	f.Fuzz(func(t *testing.T, content, ops []byte) {
+		// Every step checks the whole content, keep it fast
+		if len(content) > 1000 || len(ops) > 1000 {
+			t.Skip()
+		}
+		runOps(t, content, ops)
+	})
+}
+
+// TestRandomOperations runs random operation sequences, so that the fuzz
+// target gets decent coverage on a plain `go test` as well.
+func TestRandomOperations(t *testing.T) {
+	for seed := int64(0); seed < 200; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		content := make([]byte, rng.Intn(20))
+		rng.Read(content)
+		ops := make([]byte, 2*rng.Intn(200))
+		rng.Read(ops)
+		runOps(t, content, ops)
+	}
+}
+
+func FuzzSplit(f *testing.F) {
+	f.Add([]byte("a\nb\n\nc"), byte('\n'))
+	f.Add([]byte{}, byte(0))
+	f.Fuzz(func(t *testing.T, content []byte, sep byte) {
+		gb := NewGapBuffer()
+		gb.SetContent(bytes.Clone(content))
+
+		got := gb.Split(sep)
+		want := bytes.Split(content, []byte{sep})
+		if len(got) != len(want) {
+			t.Fatalf("got %d parts, want %d", len(got), len(want))
+		}
+		for i := range want {
+			if !bytes.Equal(got[i], want[i]) {
+				t.Fatalf("part %d: got %q, want %q", i, got[i], want[i])
+			}
+		}
+	})
+}
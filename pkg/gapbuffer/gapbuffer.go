@@ -2,9 +2,12 @@ package gapbuffer
 
 import (
 	"bytes"
+	"errors"
 	"flag"
 	"io"
 	"strings"
+
+	"github.com/Ardelean-Calin/elmo/pkg/storage"
 )
 
 // Gap Buffer implementation. See: https://routley.io/posts/gap-buffer
@@ -12,6 +15,12 @@ type GapBuffer struct {
 	Buffer   []byte // NOTE: In the future I might want this to be a pointer. I avoided this for now as it looked ugly
 	GapStart int    // Index of the first character *in* the gap
 	GapEnd   int    // Index of the first character *after* the gap
+
+	// Set while Buffer is also referenced by a snapshot. Only the range
+	// [sharedStart, sharedEnd), which was gap for every snapshot, can then
+	// be written in place.
+	shared                 bool
+	sharedStart, sharedEnd int
 }
 
 // NewGapBuffer creates a new empty gapbuffer
@@ -26,10 +35,24 @@ func NewGapBuffer() GapBuffer {
 // SetContent sets the gapbuffer content
 func (gb *GapBuffer) SetContent(content []byte) {
 	gb.Buffer = content
+	gb.GapStart = 0
+	gb.GapEnd = 0
+	gb.shared = false
+}
+
+// writable makes sure the range [lo, hi) of the buffer can be written to
+// without altering any snapshot. Copies the buffer if needed.
+func (gb *GapBuffer) writable(lo, hi int) {
+	if gb.shared && (lo < gb.sharedStart || hi > gb.sharedEnd) {
+		gb.Buffer = bytes.Clone(gb.Buffer)
+		gb.shared = false
+	}
 }
 
+// Reader returns a reader over the content. It reads straight from the
+// buffer, so the content must not change while reading.
 func (gb *GapBuffer) Reader() io.Reader {
-	return bytes.NewBuffer(gb.Bytes())
+	return io.NewSectionReader(gb, 0, int64(gb.Len()))
 }
 
 // Bytes returns a slice of the content of the gap buffer, without gap
@@ -40,8 +63,9 @@ func (gb *GapBuffer) Bytes() []byte {
 	return dest
 }
 
+// Split splits the content around every occurrence of sep, like bytes.Split
 func (gb *GapBuffer) Split(sep byte) [][]byte {
-	var splits [][]byte = make([][]byte, len(gb.FindAll(sep)))
+	var splits [][]byte = make([][]byte, len(gb.FindAll(sep))+1)
 	index := 0
 	for _, r := range gb.Bytes() {
 		if r == sep {
@@ -106,7 +130,8 @@ func (gb *GapBuffer) growGap() {
 	copy(newBuffer[gb.GapEnd+gapSize:], gb.Buffer[gb.GapEnd:])
 
 	gb.Buffer = newBuffer
-	gb.GapEnd = gb.GapStart + gapSize
+	gb.GapEnd += gapSize
+	gb.shared = false
 }
 
 // Len returns the total length of the gap buffer, excluding gap
@@ -124,14 +149,15 @@ func (gb *GapBuffer) Cursor() int {
 	return gb.GapStart
 }
 
-// Current returns the element at the current cursor position
+// Current returns the element at the current cursor position, which is the
+// first element after the gap
 func (gb *GapBuffer) Current() byte {
-	return gb.Buffer[gb.GapStart]
+	return gb.Buffer[gb.GapEnd]
 }
 
 // Next returns the next element after the cursor
 func (gb *GapBuffer) Next() byte {
-	if gb.GapEnd+1 >= gb.Len() {
+	if gb.GapEnd+1 >= len(gb.Buffer) {
 		return gb.Current()
 	}
 
@@ -139,52 +165,35 @@ func (gb *GapBuffer) Next() byte {
 }
 
 // GetAbs returns the element at the given position. Ignores gap and treats buffer
-// as a linear array
+// as a linear array. The position is clamped to the buffer length.
 func (gb *GapBuffer) GetAbs(pos int) byte {
-	if pos > gb.GapStart {
-		pos += gb.gapSize()
-	}
-
-	pos = clamp(pos, 0, gb.Len())
-
-	return gb.Buffer[pos]
+	return gb.ByteAt(clamp(pos, 0, gb.Len()))
 }
 
-// CursorGoto moves the cursor to the given (absolute) position
+// CursorGoto moves the cursor to the given (absolute) position, clamped to
+// the content
 func (gb *GapBuffer) CursorGoto(pos int) (actualPos int) {
-	if pos+gb.gapSize() > gb.Len() {
-		pos = gb.Len() - gb.gapSize()
-	}
+	pos = max(0, min(pos, gb.Len()))
+	gb.moveGap(pos)
+	return pos
+}
 
+// moveGap moves the gap to pos, which must be within [0, Len()]. Only the
+// bytes between the old and the new gap get moved, in place.
+func (gb *GapBuffer) moveGap(pos int) {
+	gap := gb.gapSize()
 	if pos > gb.GapStart {
 		// [a b _ _ _ c d e f] becomes [a b c d e _ _ _ f]
-		diff := pos - gb.GapStart
-		copy(gb.Buffer[gb.GapStart:], gb.Buffer[gb.GapEnd:gb.GapEnd+diff])
+		gb.writable(gb.GapStart, pos)
+		copy(gb.Buffer[gb.GapStart:pos], gb.Buffer[gb.GapEnd:pos+gap])
 	} else if pos < gb.GapStart {
 		// [a b c d e _ _ _ f] becomes [a b _ _ _ c d e f]
-		//      ^     s     e               s     e
-		//                                  ^
-		// Step 0: New empty buffer [_ _ _ _ _ _ _ _ _]
-		newBuf := make([]byte, gb.TotalLen())
-		i := 0
-
-		// Step 1: Copy part until new position to buffer
-		// [a b _ _ _ _ _ _ _]
-		i += copy(newBuf[i:pos], gb.Buffer[i:pos])
-		// Step 2: Leave a gap
-		i += gb.gapSize()
-		// Step 3: Copy part from position to gapStart
-		// [a b _ _ _ c d e _]
-		i += copy(newBuf[i:], gb.Buffer[pos:gb.GapStart])
-		// Finally: Copy all the bytes from gapEnd to end
-		copy(newBuf[i:], gb.Buffer[gb.GapEnd:])
-
-		gb.Buffer = newBuf
+		// copy behaves like memmove, so the ranges may overlap
+		gb.writable(pos+gap, gb.GapEnd)
+		copy(gb.Buffer[pos+gap:gb.GapEnd], gb.Buffer[pos:gb.GapStart])
 	}
-	gb.GapEnd = pos + gb.gapSize()
+	gb.GapEnd = pos + gap
 	gb.GapStart = pos
-	actualPos = pos
-	return
 }
 
 // CursorRight moves the cursor left one character.
@@ -197,6 +206,7 @@ func (gb *GapBuffer) CursorLeft() {
 
 	// The first element before the start of the gap gets copied after the gap
 	// [abc_____] becomes [ab_____c]
+	gb.writable(gb.GapEnd-1, gb.GapEnd)
 	gb.Buffer[gb.GapEnd-1] = gb.Buffer[gb.GapStart-1]
 	gb.GapStart--
 	gb.GapEnd--
@@ -206,11 +216,12 @@ func (gb *GapBuffer) CursorLeft() {
 // NOTE: The cursor is always the start of the gap
 func (gb *GapBuffer) CursorRight() {
 	// We are already at the end!
-	if gb.GapEnd == len(gb.Buffer)-1 {
+	if gb.GapEnd == len(gb.Buffer) {
 		return
 	}
 
 	// [ab_____c] becomes [abc_____]
+	gb.writable(gb.GapStart, gb.GapStart+1)
 	gb.Buffer[gb.GapStart] = gb.Buffer[gb.GapEnd]
 	gb.GapStart++
 	gb.GapEnd++
@@ -229,6 +240,7 @@ func (gb *GapBuffer) Insert(el byte) {
 		gb.growGap()
 	}
 
+	gb.writable(gb.GapStart, gb.GapStart+1)
 	gb.Buffer[gb.GapStart] = el
 	gb.GapStart++
 }
@@ -243,12 +255,13 @@ func (gb *GapBuffer) Delete() {
 	gb.GapEnd++
 }
 
+// DeleteRange deletes length characters starting at the current cursor position.
 func (gb *GapBuffer) DeleteRange(length int) {
-	if gb.GapEnd == len(gb.Buffer) {
+	if gb.GapEnd == len(gb.Buffer) || length <= 0 {
 		return
 	}
 
-	gb.GapEnd = clamp(gb.GapEnd+length, 0, len(gb.Buffer))
+	gb.GapEnd = min(gb.GapEnd+length, len(gb.Buffer))
 }
 
 // Backspace deletes the character before the current position
@@ -271,6 +284,140 @@ func (gb *GapBuffer) String() string {
 	return sb.String()
 }
 
+// Storage interface. See pkg/storage.
+
+var _ storage.Storage = (*GapBuffer)(nil)
+
+// ByteAt returns the byte at the given absolute position, ignoring the gap
+func (gb *GapBuffer) ByteAt(pos int) byte {
+	if pos < 0 || pos >= gb.Len() {
+		panic("gapbuffer: position out of range")
+	}
+	if pos >= gb.GapStart {
+		pos += gb.gapSize()
+	}
+	return gb.Buffer[pos]
+}
+
+// halves returns the content before and after the gap, without copying
+func (gb *GapBuffer) halves() gapSnapshot {
+	return gapSnapshot{gb.Buffer[:gb.GapStart], gb.Buffer[gb.GapEnd:]}
+}
+
+// Slice returns a copy of the content in the range [start, end)
+func (gb *GapBuffer) Slice(start, end int) []byte {
+	return gb.halves().Slice(start, end)
+}
+
+// AppendRange appends the content in the range [start, end) to dst
+func (gb *GapBuffer) AppendRange(dst []byte, start, end int) []byte {
+	return gb.halves().AppendRange(dst, start, end)
+}
+
+// ReadAt implements io.ReaderAt, copying straight out of the buffer
+func (gb *GapBuffer) ReadAt(p []byte, off int64) (int, error) {
+	return gb.halves().ReadAt(p, off)
+}
+
+// WriteTo implements io.WriterTo by writing the two halves around the gap
+func (gb *GapBuffer) WriteTo(w io.Writer) (int64, error) {
+	return gb.halves().WriteTo(w)
+}
+
+// InsertAt inserts text at the given position
+func (gb *GapBuffer) InsertAt(pos int, text []byte) {
+	gb.moveGap(max(0, min(pos, gb.Len())))
+	gb.InsertSlice(text)
+}
+
+// DeleteAt deletes length bytes starting at the given position
+func (gb *GapBuffer) DeleteAt(pos, length int) {
+	if length <= 0 {
+		return
+	}
+	gb.moveGap(max(0, min(pos, gb.Len())))
+	gb.DeleteRange(length)
+}
+
+// Snapshot returns an immutable view of the current content. It shares the
+// buffer, which will be copied on the first write outside the gap.
+func (gb *GapBuffer) Snapshot() storage.Text {
+	if gb.shared {
+		gb.sharedStart = max(gb.sharedStart, gb.GapStart)
+		gb.sharedEnd = min(gb.sharedEnd, gb.GapEnd)
+	} else {
+		gb.shared = true
+		gb.sharedStart, gb.sharedEnd = gb.GapStart, gb.GapEnd
+	}
+	return gapSnapshot{
+		before: gb.Buffer[:gb.GapStart:gb.GapStart],
+		after:  gb.Buffer[gb.GapEnd:len(gb.Buffer):len(gb.Buffer)],
+	}
+}
+
+// gapSnapshot holds the two halves around the gap at the time of a snapshot
+type gapSnapshot struct {
+	before, after []byte
+}
+
+func (s gapSnapshot) Len() int {
+	return len(s.before) + len(s.after)
+}
+
+func (s gapSnapshot) ByteAt(pos int) byte {
+	if pos < len(s.before) {
+		return s.before[pos]
+	}
+	return s.after[pos-len(s.before)]
+}
+
+func (s gapSnapshot) Slice(start, end int) []byte {
+	return s.AppendRange(nil, start, end)
+}
+
+func (s gapSnapshot) AppendRange(dst []byte, start, end int) []byte {
+	start = max(0, min(start, s.Len()))
+	end = max(start, min(end, s.Len()))
+	if dst == nil {
+		dst = make([]byte, 0, end-start)
+	}
+	if start < len(s.before) {
+		dst = append(dst, s.before[start:min(end, len(s.before))]...)
+	}
+	if end > len(s.before) {
+		dst = append(dst, s.after[max(start, len(s.before))-len(s.before):end-len(s.before)]...)
+	}
+	return dst
+}
+
+func (s gapSnapshot) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("gapbuffer: negative offset")
+	}
+	if off >= int64(s.Len()) {
+		return 0, io.EOF
+	}
+	// p has enough capacity, so this copies in place
+	n := len(s.AppendRange(p[:0], int(off), int(off)+len(p)))
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (s gapSnapshot) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(s.before)
+	if err != nil {
+		return int64(n), err
+	}
+	m, err := w.Write(s.after)
+	return int64(n + m), err
+}
+
+func (s gapSnapshot) Bytes() []byte {
+	return s.Slice(0, s.Len())
+}
+
 /* Provide an iterator interface for the GapBuffer.
    This iterator jumps over gaps.
 */
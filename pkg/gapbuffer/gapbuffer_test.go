@@ -3,6 +3,8 @@ package gapbuffer
 import (
 	"testing"
 
+	"github.com/Ardelean-Calin/elmo/pkg/storage"
+	"github.com/Ardelean-Calin/elmo/pkg/storage/storagetest"
 	"github.com/matryer/is"
 )
 
@@ -109,3 +111,11 @@ func TestDelete(t *testing.T) {
 	got := b.Bytes()
 	is.Equal(got, want)
 }
+
+func TestStorage(t *testing.T) {
+	storagetest.TestStorage(t, func(content []byte) storage.Storage {
+		b := NewGapBuffer()
+		b.SetContent(content)
+		return &b
+	})
+}
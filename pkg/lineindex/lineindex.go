@@ -0,0 +1,347 @@
+package lineindex
+
+import (
+	"bytes"
+	"math/rand"
+)
+
+// blockSize is the maximum number of lines stored in a single node
+var blockSize = 256
+
+// scanSize is roughly how many bytes a lazy index reads at once, see NewLazy
+var scanSize = 64 << 10
+
+// Index keeps track of the lines inside a text buffer. Lines are stored in
+// blocks, which are the nodes of an implicit treap ordered by line number.
+// Every node stores the lengths of its lines (including the trailing '\n')
+// and the sums of its subtree. This way both offset->(line, column) and
+// (line, column)->offset conversions are O(log n), and edits only touch the
+// lines they affect instead of rescanning the whole buffer. Keeping lines in
+// blocks makes the index of a huge file only cost a few bytes per line.
+//
+// Every line except the last one ends with a '\n'. An empty buffer has a
+// single, empty line.
+type Index struct {
+	root *node
+	rng  *rand.Rand
+	// End of the content, which hasn't been indexed yet. When set, the
+	// indexed lines end with a '\n' and the last, empty line of the tree
+	// is where it starts.
+	rest []byte
+}
+
+type node struct {
+	left, right *node
+	priority    uint32
+	lengths     []int // Lengths of the lines in this block, including '\n'
+	length      int   // Sum of lengths
+	count       int   // Number of lines in this subtree
+	size        int   // Number of bytes in this subtree
+}
+
+// update recalculates the subtree sums of n
+func (n *node) update() {
+	n.count = len(n.lengths) + n.left.lines() + n.right.lines()
+	n.size = n.length + n.left.bytes() + n.right.bytes()
+}
+
+func (n *node) lines() int {
+	if n == nil {
+		return 0
+	}
+	return n.count
+}
+
+func (n *node) bytes() int {
+	if n == nil {
+		return 0
+	}
+	return n.size
+}
+
+// New creates a new line index for the given content
+func New(content []byte) *Index {
+	ix := &Index{rng: rand.New(rand.NewSource(0x656c6d6f))}
+	ix.root = ix.build(lineLengths(content))
+	return ix
+}
+
+// NewLazy creates a line index which only reads content as far as it is
+// asked about, which saves reading all of a huge file to show its first
+// lines. Content must not be modified afterwards, edits go through Insert
+// and Delete as usual.
+func NewLazy(content []byte) *Index {
+	ix := &Index{rng: rand.New(rand.NewSource(0x656c6d6f)), rest: content}
+	ix.root = ix.block([]int{0})
+	return ix
+}
+
+// scan indexes the next part of the content which isn't indexed yet, up to
+// the end of a line
+func (ix *Index) scan() {
+	n := min(scanSize, len(ix.rest))
+	if i := bytes.IndexByte(ix.rest[n:], '\n'); i >= 0 {
+		n += i + 1
+	} else {
+		n = len(ix.rest)
+	}
+	// The new lines replace the empty one at the end
+	left, _ := ix.split(ix.root, ix.root.lines()-1)
+	ix.root = merge(left, ix.build(lineLengths(ix.rest[:n])))
+	ix.rest = ix.rest[n:]
+}
+
+// scanLine indexes the content until the given line is complete
+func (ix *Index) scanLine(line int) {
+	for len(ix.rest) > 0 && ix.root.lines()-1 <= line {
+		ix.scan()
+	}
+}
+
+// scanOffset indexes the content until the line containing offset is
+// complete
+func (ix *Index) scanOffset(offset int) {
+	for len(ix.rest) > 0 && ix.root.bytes() <= offset {
+		ix.scan()
+	}
+}
+
+// lineLengths splits content into lines and returns their lengths, including
+// the trailing '\n'.
+func lineLengths(content []byte) []int {
+	lengths := make([]int, 0, bytes.Count(content, []byte{'\n'})+1)
+	start := 0
+	for {
+		i := bytes.IndexByte(content[start:], '\n')
+		if i < 0 {
+			break
+		}
+		lengths = append(lengths, i+1)
+		start += i + 1
+	}
+	return append(lengths, len(content)-start)
+}
+
+// block creates a new node holding the given lines
+func (ix *Index) block(lengths []int) *node {
+	n := &node{priority: ix.rng.Uint32(), lengths: lengths}
+	for _, l := range lengths {
+		n.length += l
+	}
+	n.update()
+	return n
+}
+
+// build creates a treap containing the given lines in O(n). Works like a
+// cartesian tree construction: the right spine is kept on a stack.
+func (ix *Index) build(lengths []int) *node {
+	var spine []*node
+	for i := 0; i < len(lengths); i += blockSize {
+		end := min(i+blockSize, len(lengths))
+		n := ix.block(lengths[i:end:end])
+		var last *node
+		for len(spine) > 0 && spine[len(spine)-1].priority < n.priority {
+			last = spine[len(spine)-1]
+			spine = spine[:len(spine)-1]
+			last.update()
+		}
+		n.left = last
+		if len(spine) > 0 {
+			spine[len(spine)-1].right = n
+		}
+		spine = append(spine, n)
+	}
+	for i := len(spine) - 1; i >= 0; i-- {
+		spine[i].update()
+	}
+	if len(spine) == 0 {
+		return nil
+	}
+	return spine[0]
+}
+
+// split splits the tree into the first k lines and the rest. A block
+// containing the split point is itself split in two.
+func (ix *Index) split(n *node, k int) (*node, *node) {
+	if n == nil {
+		return nil, nil
+	}
+	left := n.left.lines()
+	if k <= left {
+		l, r := ix.split(n.left, k)
+		n.left = r
+		n.update()
+		return l, n
+	}
+	if k >= left+len(n.lengths) {
+		l, r := ix.split(n.right, k-left-len(n.lengths))
+		n.right = l
+		n.update()
+		return n, r
+	}
+
+	i := k - left
+	head := ix.block(n.lengths[:i:i])
+	tail := ix.block(n.lengths[i:])
+	return merge(n.left, head), merge(tail, n.right)
+}
+
+// merge joins two trees, with all lines of a coming before the lines of b
+func merge(a, b *node) *node {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if a.priority > b.priority {
+		a.right = merge(a.right, b)
+		a.update()
+		return a
+	}
+	b.left = merge(a, b.left)
+	b.update()
+	return b
+}
+
+// Len returns the number of lines. A lazy index has to read all of the
+// content to know it.
+func (ix *Index) Len() int {
+	for len(ix.rest) > 0 {
+		ix.scan()
+	}
+	return ix.root.lines()
+}
+
+// HasLine reports whether the given line exists, only reading the content
+// up to it
+func (ix *Index) HasLine(line int) bool {
+	ix.scanLine(line)
+	return 0 <= line && line < ix.root.lines()
+}
+
+// Size returns the number of bytes covered by the index
+func (ix *Index) Size() int {
+	return ix.root.bytes() + len(ix.rest)
+}
+
+// Line returns the byte range [start, end) of the given line, excluding the
+// trailing '\n'. The line number is clamped to the existing lines.
+func (ix *Index) Line(line int) (start, end int) {
+	line = max(line, 0)
+	ix.scanLine(line)
+	line = min(line, ix.root.lines()-1)
+	last := line == ix.root.lines()-1
+
+	n := ix.root
+	for n != nil {
+		left := n.left.lines()
+		if line < left {
+			n = n.left
+			continue
+		}
+		start += n.left.bytes()
+		line -= left
+		if line < len(n.lengths) {
+			for _, l := range n.lengths[:line] {
+				start += l
+			}
+			end = start + n.lengths[line]
+			break
+		}
+		start += n.length
+		line -= len(n.lengths)
+		n = n.right
+	}
+
+	if !last {
+		end--
+	}
+	return start, end
+}
+
+// Offset converts a (line, column) pair to an absolute offset. The column is
+// clamped to the line length, so it is safe to use it with a column taken
+// from a longer line.
+func (ix *Index) Offset(line, col int) int {
+	start, end := ix.Line(line)
+	return start + max(0, min(col, end-start))
+}
+
+// Position converts an absolute offset to a (line, column) pair. Offsets
+// outside the buffer are clamped.
+func (ix *Index) Position(offset int) (line, col int) {
+	offset = max(0, min(offset, ix.Size()))
+	ix.scanOffset(offset)
+	n := ix.root
+	for n != nil {
+		if offset < n.left.bytes() {
+			n = n.left
+			continue
+		}
+		offset -= n.left.bytes()
+		line += n.left.lines()
+		// The last line also owns the offset right after it (EOF)
+		if offset < n.length || n.right == nil && offset == n.length {
+			for i, l := range n.lengths {
+				if offset < l || i == len(n.lengths)-1 {
+					return line + i, offset
+				}
+				offset -= l
+			}
+		}
+		offset -= n.length
+		line += len(n.lengths)
+		n = n.right
+	}
+	// Only reachable for an offset equal to the size of a buffer whose last
+	// node is not on the right spine, which cannot happen.
+	return ix.Len() - 1, 0
+}
+
+// Insert updates the index after text was inserted at offset
+func (ix *Index) Insert(offset int, text []byte) {
+	if len(text) == 0 {
+		return
+	}
+	line, col := ix.Position(offset)
+	left, rest := ix.split(ix.root, line)
+	current, right := ix.split(rest, 1)
+
+	lengths := lineLengths(text)
+	if len(lengths) == 1 {
+		current = ix.block([]int{current.length + lengths[0]})
+		ix.root = merge(merge(left, current), right)
+		return
+	}
+
+	// The current line gets split in two, with the new lines in between
+	tail := current.length - col
+	lengths[0] += col
+	lengths[len(lengths)-1] += tail
+	ix.root = merge(merge(left, ix.build(lengths)), right)
+}
+
+// Delete updates the index after length bytes were deleted at offset
+func (ix *Index) Delete(offset, length int) {
+	offset = max(0, min(offset, ix.Size()))
+	length = max(0, min(length, ix.Size()-offset))
+	if length == 0 {
+		return
+	}
+	first, col := ix.Position(offset)
+	last, lastCol := ix.Position(offset + length)
+
+	left, rest := ix.split(ix.root, first)
+	middle, right := ix.split(rest, last-first+1)
+
+	// All the affected lines collapse into a single one
+	_, lastLine := ix.split(middle, last-first)
+	merged := ix.block([]int{col + lastLine.length - lastCol})
+	ix.root = merge(merge(left, merged), right)
+}
+
+// clamp limits the value of val between [low, high)
+func clamp(val, low, high int) int {
+	return max(low, min(val, high-1))
+}
@@ -0,0 +1,191 @@
+package lineindex
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+	"testing/quick"
+
+	"github.com/matryer/is"
+)
+
+// naive is the reference implementation: it rescans the whole content for
+// every query.
+type naive []byte
+
+func (n naive) lines() [][2]int {
+	var lines [][2]int
+	start := 0
+	for i, c := range n {
+		if c == '\n' {
+			lines = append(lines, [2]int{start, i})
+			start = i + 1
+		}
+	}
+	return append(lines, [2]int{start, len(n)})
+}
+
+func (n naive) position(offset int) (int, int) {
+	for i, l := range n.lines() {
+		if offset <= l[1] {
+			return i, offset - l[0]
+		}
+	}
+	panic("offset out of range")
+}
+
+// check compares every line and every offset of ix against the reference
+func check(t *testing.T, ix *Index, ref naive) {
+	t.Helper()
+	is := is.NewRelaxed(t)
+
+	lines := ref.lines()
+	is.Equal(ix.Len(), len(lines))
+	is.Equal(ix.Size(), len(ref))
+	for i, l := range lines {
+		start, end := ix.Line(i)
+		is.Equal([2]int{start, end}, l)
+		is.Equal(ix.Offset(i, end-start), l[1])
+	}
+	for offset := 0; offset <= len(ref); offset++ {
+		line, col := ix.Position(offset)
+		wantLine, wantCol := ref.position(offset)
+		is.Equal(line, wantLine)
+		is.Equal(col, wantCol)
+		is.Equal(ix.Offset(line, col), offset)
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	is := is.New(t)
+
+	ix := New(nil)
+	is.Equal(ix.Len(), 1)
+	start, end := ix.Line(0)
+	is.Equal(start, 0)
+	is.Equal(end, 0)
+	line, col := ix.Position(0)
+	is.Equal(line, 0)
+	is.Equal(col, 0)
+}
+
+func TestBasic(t *testing.T) {
+	content := naive("package main\n\nfunc main() {\n\tprintln(\"hi\")\n}\n")
+	check(t, New(content), content)
+}
+
+func TestInsertDelete(t *testing.T) {
+	is := is.New(t)
+
+	ix := New([]byte("Hello\nWorld"))
+	ix.Insert(5, []byte(",\nMy\nDear"))
+	check(t, ix, naive("Hello,\nMy\nDear\nWorld"))
+
+	ix.Delete(3, 10)
+	check(t, ix, naive("Helr\nWorld"))
+
+	ix.Delete(0, 100)
+	is.Equal(ix.Len(), 1)
+	is.Equal(ix.Size(), 0)
+}
+
+// TestRandomEdits applies the same random edits to the index and to the
+// reference and compares them after every step.
+func TestRandomEdits(t *testing.T) {
+	// Small blocks so that the tests exercise splitting them
+	defer func(n int) { blockSize = n }(blockSize)
+	blockSize = 3
+
+	alphabet := []byte("ab\n\n")
+	for seed := int64(0); seed < 50; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		var ref naive
+		ix := New(nil)
+		for step := 0; step < 100; step++ {
+			offset := rng.Intn(len(ref) + 1)
+			if rng.Intn(3) == 0 {
+				length := rng.Intn(8)
+				ix.Delete(offset, length)
+				end := min(offset+length, len(ref))
+				ref = append(ref[:offset:offset], ref[end:]...)
+			} else {
+				text := make([]byte, rng.Intn(6))
+				for i := range text {
+					text[i] = alphabet[rng.Intn(len(alphabet))]
+				}
+				ix.Insert(offset, text)
+				ref = append(ref[:offset:offset], append(text, ref[offset:]...)...)
+			}
+			check(t, ix, ref)
+			if t.Failed() {
+				t.Fatalf("seed %d, step %d: %q", seed, step, ref)
+			}
+		}
+	}
+}
+
+// TestLazy checks that a lazy index only reads what it needs, and otherwise
+// works like a complete one
+func TestLazy(t *testing.T) {
+	is := is.New(t)
+	defer func(n int) { scanSize = n }(scanSize)
+	scanSize = 4
+
+	content := naive("package main\n\nfunc main() {\n\tprintln(\"hi\")\n}\n")
+	ix := NewLazy(content)
+	is.True(ix.HasLine(1))
+	start, end := ix.Line(0)
+	is.Equal(string(content[start:end]), "package main")
+	is.Equal(ix.Size(), len(content))
+	is.True(len(ix.rest) > 0)
+	is.True(!ix.HasLine(6))
+	is.Equal(len(ix.rest), 0)
+	check(t, ix, content)
+
+	// Edits read the content up to them
+	alphabet := []byte("ab\n\n")
+	for seed := int64(0); seed < 50; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		ref := make(naive, 40)
+		for i := range ref {
+			ref[i] = alphabet[rng.Intn(len(alphabet))]
+		}
+		ix := NewLazy(slices.Clone(ref))
+		for step := 0; step < 20; step++ {
+			offset := rng.Intn(len(ref) + 1)
+			switch rng.Intn(3) {
+			case 0:
+				length := rng.Intn(8)
+				ix.Delete(offset, length)
+				end := min(offset+length, len(ref))
+				ref = append(ref[:offset:offset], ref[end:]...)
+			case 1:
+				text := []byte{alphabet[rng.Intn(len(alphabet))]}
+				ix.Insert(offset, text)
+				ref = append(ref[:offset:offset], append(text, ref[offset:]...)...)
+			default:
+				line, col := ix.Position(offset)
+				wantLine, wantCol := ref.position(offset)
+				is.Equal([2]int{line, col}, [2]int{wantLine, wantCol})
+			}
+		}
+		check(t, ix, ref)
+		if t.Failed() {
+			t.Fatalf("seed %d: %q", seed, ref)
+		}
+	}
+}
+
+// TestQuickRoundTrip checks that Position and Offset are inverse functions
+func TestQuickRoundTrip(t *testing.T) {
+	roundTrip := func(content []byte, offset uint16) bool {
+		ix := New(content)
+		o := int(offset) % (len(content) + 1)
+		line, col := ix.Position(o)
+		wantLine, wantCol := naive(content).position(o)
+		return line == wantLine && col == wantCol && ix.Offset(line, col) == o
+	}
+	if err := quick.Check(roundTrip, nil); err != nil {
+		t.Error(err)
+	}
+}
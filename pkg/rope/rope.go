@@ -0,0 +1,259 @@
+package rope
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/Ardelean-Calin/elmo/pkg/storage"
+)
+
+// maxLeaf is the maximum size of the leaves created for inserted text and
+// when merging small neighbouring leaves.
+var maxLeaf = 1024
+
+// chunkSize is the size of the leaves created by New. Their content is never
+// copied, so they can be a lot larger than maxLeaf, keeping the tree small
+// for huge (memory mapped) files.
+var chunkSize = 64 << 10
+
+// Rope is a balanced (AVL) binary tree of byte chunks. Nodes are never
+// modified once created, so a snapshot is just a copy of the root pointer and
+// every edit allocates only O(log n) new nodes. This makes it a better fit
+// than the gap buffer for huge files where copying everything is not an
+// option.
+type Rope struct {
+	root *node
+}
+
+var _ storage.Storage = (*Rope)(nil)
+
+type node struct {
+	left, right *node
+	data        []byte // Only set for leaves
+	length      int    // Total length of the subtree
+	height      int    // Leaves have height 0
+}
+
+// New creates a new rope holding content. The rope takes ownership of
+// content, which must not be modified afterwards. Content is never written
+// to, so it can also be read-only memory.
+func New(content []byte) *Rope {
+	return &Rope{root: build(content, chunkSize)}
+}
+
+func leaf(data []byte) *node {
+	return &node{data: data, length: len(data)}
+}
+
+func branch(left, right *node) *node {
+	return &node{
+		left:   left,
+		right:  right,
+		length: left.length + right.length,
+		height: 1 + max(left.height, right.height),
+	}
+}
+
+func (n *node) isLeaf() bool {
+	return n.left == nil
+}
+
+// build creates a balanced tree out of content, split into chunks of size
+func build(content []byte, size int) *node {
+	if len(content) == 0 {
+		return nil
+	}
+	if len(content) <= size {
+		return leaf(content)
+	}
+	chunks := (len(content) + size - 1) / size
+	mid := chunks / 2 * size
+	return branch(build(content[:mid], size), build(content[mid:], size))
+}
+
+// balance creates a node out of two trees whose heights differ by at most 2
+func balance(l, r *node) *node {
+	if l.height > r.height+1 {
+		if l.left.height >= l.right.height {
+			return branch(l.left, branch(l.right, r))
+		}
+		return branch(branch(l.left, l.right.left), branch(l.right.right, r))
+	}
+	if r.height > l.height+1 {
+		if r.right.height >= r.left.height {
+			return branch(branch(l, r.left), r.right)
+		}
+		return branch(branch(l, r.left.left), branch(r.left.right, r.right))
+	}
+	return branch(l, r)
+}
+
+// join concatenates two trees, keeping the result balanced. Neighbouring
+// small leaves are merged so that typing doesn't create a leaf per key.
+func join(l, r *node) *node {
+	if l == nil {
+		return r
+	}
+	if r == nil {
+		return l
+	}
+	if l.isLeaf() && r.isLeaf() && l.length+r.length <= maxLeaf {
+		data := make([]byte, 0, l.length+r.length)
+		return leaf(append(append(data, l.data...), r.data...))
+	}
+
+	if l.height > r.height+1 {
+		return balance(l.left, join(l.right, r))
+	}
+	if r.height > l.height+1 {
+		return balance(join(l, r.left), r.right)
+	}
+	return branch(l, r)
+}
+
+// split splits the tree into the first pos bytes and the rest
+func split(n *node, pos int) (*node, *node) {
+	if n == nil || pos <= 0 {
+		return nil, n
+	}
+	if pos >= n.length {
+		return n, nil
+	}
+	if n.isLeaf() {
+		// Limit the capacity so nobody can append over the right half
+		return leaf(n.data[:pos:pos]), leaf(n.data[pos:])
+	}
+
+	if pos < n.left.length {
+		l, r := split(n.left, pos)
+		return l, join(r, n.right)
+	}
+	l, r := split(n.right, pos-n.left.length)
+	return join(n.left, l), r
+}
+
+// Len returns the length of the rope, in bytes
+func (r *Rope) Len() int {
+	if r.root == nil {
+		return 0
+	}
+	return r.root.length
+}
+
+// ByteAt returns the byte at the given position
+func (r *Rope) ByteAt(pos int) byte {
+	if pos < 0 || pos >= r.Len() {
+		panic("rope: position out of range")
+	}
+	n := r.root
+	for !n.isLeaf() {
+		if pos < n.left.length {
+			n = n.left
+		} else {
+			pos -= n.left.length
+			n = n.right
+		}
+	}
+	return n.data[pos]
+}
+
+// Slice returns a copy of the content in the range [start, end)
+func (r *Rope) Slice(start, end int) []byte {
+	return r.AppendRange(nil, start, end)
+}
+
+// AppendRange appends the content in the range [start, end) to dst
+func (r *Rope) AppendRange(dst []byte, start, end int) []byte {
+	start = max(0, min(start, r.Len()))
+	end = max(start, min(end, r.Len()))
+	if dst == nil {
+		dst = make([]byte, 0, end-start)
+	}
+	return appendRange(dst, r.root, start, end)
+}
+
+// ReadAt implements io.ReaderAt, copying straight out of the leaves
+func (r *Rope) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("rope: negative offset")
+	}
+	if off >= int64(r.Len()) {
+		return 0, io.EOF
+	}
+	// p has enough capacity, so this copies in place
+	n := len(r.AppendRange(p[:0], int(off), int(off)+len(p)))
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteTo implements io.WriterTo by writing every leaf in order
+func (r *Rope) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, r.root)
+}
+
+func writeTo(w io.Writer, n *node) (int64, error) {
+	if n == nil {
+		return 0, nil
+	}
+	if n.isLeaf() {
+		written, err := w.Write(n.data)
+		return int64(written), err
+	}
+	written, err := writeTo(w, n.left)
+	if err != nil {
+		return written, err
+	}
+	more, err := writeTo(w, n.right)
+	return written + more, err
+}
+
+// appendRange appends the bytes of n in the range [start, end) to dest
+func appendRange(dest []byte, n *node, start, end int) []byte {
+	if n == nil || start >= end {
+		return dest
+	}
+	if n.isLeaf() {
+		return append(dest, n.data[start:end]...)
+	}
+	if start < n.left.length {
+		dest = appendRange(dest, n.left, start, min(end, n.left.length))
+	}
+	if end > n.left.length {
+		dest = appendRange(dest, n.right, max(0, start-n.left.length), end-n.left.length)
+	}
+	return dest
+}
+
+// Bytes returns a copy of the whole content
+func (r *Rope) Bytes() []byte {
+	return r.Slice(0, r.Len())
+}
+
+// InsertAt inserts text at the given position
+func (r *Rope) InsertAt(pos int, text []byte) {
+	if len(text) == 0 {
+		return
+	}
+	pos = max(0, min(pos, r.Len()))
+	l, rest := split(r.root, pos)
+	r.root = join(join(l, build(bytes.Clone(text), maxLeaf)), rest)
+}
+
+// DeleteAt deletes length bytes starting at the given position
+func (r *Rope) DeleteAt(pos, length int) {
+	if length <= 0 {
+		return
+	}
+	pos = max(0, min(pos, r.Len()))
+	l, rest := split(r.root, pos)
+	_, rest = split(rest, length)
+	r.root = join(l, rest)
+}
+
+// Snapshot returns an immutable view of the current content in O(1)
+func (r *Rope) Snapshot() storage.Text {
+	return &Rope{root: r.root}
+}
@@ -0,0 +1,34 @@
+package rope
+
+import (
+	"testing"
+
+	"github.com/Ardelean-Calin/elmo/pkg/storage"
+	"github.com/Ardelean-Calin/elmo/pkg/storage/storagetest"
+	"github.com/matryer/is"
+)
+
+func TestStorage(t *testing.T) {
+	// Small leaves so that the tests exercise splits and rebalancing
+	defer func(n, c int) { maxLeaf, chunkSize = n, c }(maxLeaf, chunkSize)
+	maxLeaf, chunkSize = 8, 16
+
+	storagetest.TestStorage(t, func(content []byte) storage.Storage {
+		return New(content)
+	})
+}
+
+// TestBalanced checks that typing character by character keeps the tree
+// height logarithmic.
+func TestBalanced(t *testing.T) {
+	is := is.New(t)
+	defer func(n int) { maxLeaf = n }(maxLeaf)
+	maxLeaf = 1
+
+	r := New(nil)
+	for i := 0; i < 1<<12; i++ {
+		r.InsertAt(i/2, []byte{'a'})
+	}
+	is.Equal(r.Len(), 1<<12)
+	is.True(r.root.height <= 18) // AVL trees are at most ~1.44*log2(n) high
+}
@@ -0,0 +1,39 @@
+package storage
+
+import "io"
+
+// Text gives read-only access to a piece of text. ReadAt, WriteTo and
+// AppendRange copy straight out of the underlying storage, without building
+// a copy of the whole text first.
+type Text interface {
+	io.ReaderAt
+	io.WriterTo
+	// Len returns the length of the text, in bytes
+	Len() int
+	// ByteAt returns the byte at the given position. Panics if pos is not
+	// inside [0, Len())
+	ByteAt(pos int) byte
+	// Slice returns a copy of the bytes in the range [start, end). The range
+	// is clamped to the text bounds.
+	Slice(start, end int) []byte
+	// AppendRange appends the bytes in the range [start, end) to dst and
+	// returns the extended slice. The range is clamped to the text bounds.
+	AppendRange(dst []byte, start, end int) []byte
+	// Bytes returns a copy of the whole text
+	Bytes() []byte
+}
+
+// Storage is the backing store of an opened buffer. Positions are absolute
+// byte offsets, so implementations are free to keep their own internal
+// cursors.
+type Storage interface {
+	Text
+	// InsertAt inserts text at the given position
+	InsertAt(pos int, text []byte)
+	// DeleteAt deletes length bytes starting at the given position
+	DeleteAt(pos, length int)
+	// Snapshot returns an immutable view of the current content. It must be
+	// cheap to create and is safe to read from another goroutine while the
+	// storage keeps being edited.
+	Snapshot() Text
+}
@@ -0,0 +1,161 @@
+// Package storagetest implements a conformance test suite that every
+// storage.Storage implementation must pass.
+package storagetest
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/Ardelean-Calin/elmo/pkg/storage"
+	"github.com/matryer/is"
+)
+
+// TestStorage runs the conformance tests. newStorage must return a new
+// storage holding content and is free to take ownership of it.
+func TestStorage(t *testing.T, newStorage func(content []byte) storage.Storage) {
+	t.Run("Empty", func(t *testing.T) {
+		s := newStorage(nil)
+		checkText(t, s, nil)
+
+		s.InsertAt(0, []byte("Oi!"))
+		checkText(t, s, []byte("Oi!"))
+	})
+
+	t.Run("Content", func(t *testing.T) {
+		content := []byte("package main\n\nfunc main() {}\n")
+		s := newStorage(bytes.Clone(content))
+		checkText(t, s, content)
+	})
+
+	t.Run("Insert", func(t *testing.T) {
+		s := newStorage([]byte("HelloWorld"))
+		s.InsertAt(5, []byte(", "))
+		s.InsertAt(s.Len(), []byte("!"))
+		s.InsertAt(0, []byte("> "))
+		checkText(t, s, []byte("> Hello, World!"))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		s := newStorage([]byte("Hello, World!"))
+		s.DeleteAt(5, 2)
+		checkText(t, s, []byte("HelloWorld!"))
+		s.DeleteAt(10, 1)
+		checkText(t, s, []byte("HelloWorld"))
+		s.DeleteAt(0, 5)
+		checkText(t, s, []byte("World"))
+		s.DeleteAt(0, s.Len())
+		checkText(t, s, nil)
+	})
+
+	t.Run("Clamping", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newStorage([]byte("Hello"))
+		s.InsertAt(100, []byte("!"))
+		s.InsertAt(-5, []byte("<"))
+		checkText(t, s, []byte("<Hello!"))
+		s.DeleteAt(3, 100)
+		checkText(t, s, []byte("<He"))
+		s.DeleteAt(1, 0)
+		s.DeleteAt(1, -1)
+		checkText(t, s, []byte("<He"))
+
+		is.Equal(s.Slice(-1, 100), []byte("<He"))
+		is.Equal(s.Slice(2, 1), []byte{})
+	})
+
+	t.Run("Snapshot", func(t *testing.T) {
+		rng := rand.New(rand.NewSource(1))
+		content := randomText(rng, 100)
+		s := newStorage(bytes.Clone(content))
+
+		first := s.Snapshot()
+		model := edit(rng, s, content, 50)
+		second := s.Snapshot()
+		edit(rng, s, model, 50)
+
+		checkText(t, first, content)
+		checkText(t, second, model)
+	})
+
+	t.Run("RandomEdits", func(t *testing.T) {
+		for seed := int64(0); seed < 20; seed++ {
+			rng := rand.New(rand.NewSource(seed))
+			model := randomText(rng, rng.Intn(200))
+			s := newStorage(bytes.Clone(model))
+			for step := 0; step < 200; step++ {
+				model = edit(rng, s, model, 1)
+				checkText(t, s, model)
+				if t.Failed() {
+					t.Fatalf("seed %d, step %d", seed, step)
+				}
+			}
+		}
+	})
+}
+
+// edit applies n random edits to both s and model and returns the new model
+func edit(rng *rand.Rand, s storage.Storage, model []byte, n int) []byte {
+	for i := 0; i < n; i++ {
+		pos := rng.Intn(len(model) + 1)
+		if rng.Intn(3) == 0 {
+			length := rng.Intn(20)
+			s.DeleteAt(pos, length)
+			end := min(pos+length, len(model))
+			model = append(model[:pos:pos], model[end:]...)
+		} else {
+			text := randomText(rng, rng.Intn(30))
+			s.InsertAt(pos, text)
+			model = append(model[:pos:pos], append(text, model[pos:]...)...)
+		}
+	}
+	return model
+}
+
+func randomText(rng *rand.Rand, n int) []byte {
+	text := make([]byte, n)
+	for i := range text {
+		text[i] = "abcdefgh \n"[rng.Intn(10)]
+	}
+	return text
+}
+
+// checkText compares every read method of text against want
+func checkText(t *testing.T, text storage.Text, want []byte) {
+	t.Helper()
+	is := is.NewRelaxed(t)
+
+	is.Equal(text.Len(), len(want))
+	is.Equal(string(text.Bytes()), string(want))
+	got := make([]byte, len(want))
+	for i := range want {
+		got[i] = text.ByteAt(i)
+	}
+	is.Equal(string(got), string(want))
+
+	step := max(1, len(want)/8)
+	for start := 0; start <= len(want); start += step {
+		for end := start; end <= len(want); end += step {
+			is.Equal(string(text.Slice(start, end)), string(want[start:end]))
+			is.Equal(string(text.AppendRange([]byte(">"), start, end)), ">"+string(want[start:end]))
+		}
+
+		// ReadAt must fill the whole buffer unless it hits EOF
+		p := make([]byte, step+1)
+		n, err := text.ReadAt(p, int64(start))
+		is.Equal(string(p[:n]), string(want[start:min(start+len(p), len(want))]))
+		if start+len(p) > len(want) {
+			is.Equal(err, io.EOF)
+		} else {
+			is.NoErr(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	n, err := text.WriteTo(&buf)
+	is.NoErr(err)
+	is.Equal(n, int64(len(want)))
+	is.Equal(buf.String(), string(want))
+}
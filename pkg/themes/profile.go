@@ -0,0 +1,75 @@
+package themes
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-isatty"
+	"github.com/muesli/termenv"
+)
+
+// Values of the --color flag
+const (
+	ColorNever  = "never"
+	ColorAuto   = "auto"
+	ColorAlways = "always"
+)
+
+// osEnviron reads the real environment
+type osEnviron struct{}
+
+func (osEnviron) Environ() []string        { return os.Environ() }
+func (osEnviron) Getenv(key string) string { return os.Getenv(key) }
+
+// DetectProfile returns the color profile to use on the given terminal.
+// Colors are converted to the closest ones of the profile, so themes keep
+// working with 256 or 16 colors.
+//
+//   - never: no colors at all
+//   - auto: detect what the terminal supports. NO_COLOR disables colors,
+//     as does output which is not a terminal.
+//   - always: colors even if NO_COLOR is set or the output is not a
+//     terminal. Uses 16 colors unless the terminal supports more.
+func DetectProfile(mode string, tty io.Writer, environ termenv.Environ) (termenv.Profile, error) {
+	if environ == nil {
+		environ = osEnviron{}
+	}
+	switch mode {
+	case ColorNever:
+		return termenv.Ascii, nil
+
+	case ColorAuto, "":
+		output := termenv.NewOutput(tty, termenv.WithEnvironment(environ))
+		profile := output.EnvColorProfile()
+		if profile == termenv.Ascii && !output.EnvNoColor() && isTerminal(tty, environ) {
+			// Old terminals like plain "xterm" or "vt220" still know the 16
+			// basic colors, termenv only trusts TERM values containing "color"
+			profile = termenv.ANSI
+		}
+		return profile, nil
+
+	case ColorAlways:
+		output := termenv.NewOutput(tty, termenv.WithEnvironment(environ), termenv.WithTTY(true))
+		// Profiles with more colors have lower values
+		return min(termenv.ANSI, output.ColorProfile()), nil
+	}
+	return termenv.Ascii, fmt.Errorf("invalid --color value %q, expected never, auto or always", mode)
+}
+
+// NoColor reports whether colors are disabled. Backgrounds are invisible
+// then, so highlights like the selection have to use reverse video instead.
+func NoColor() bool {
+	return lipgloss.ColorProfile() == termenv.Ascii
+}
+
+// isTerminal reports whether tty is a terminal which can show colors
+func isTerminal(tty io.Writer, environ termenv.Environ) bool {
+	term := environ.Getenv("TERM")
+	if term == "" || term == "dumb" {
+		return false
+	}
+	file, ok := tty.(interface{ Fd() uintptr })
+	return ok && isatty.IsTerminal(file.Fd())
+}
@@ -0,0 +1,53 @@
+package themes
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/muesli/termenv"
+)
+
+// environ is a fake environment
+type environ map[string]string
+
+func (e environ) Environ() []string {
+	var vars []string
+	for k, v := range e {
+		vars = append(vars, k+"="+v)
+	}
+	return vars
+}
+
+func (e environ) Getenv(key string) string {
+	return e[key]
+}
+
+func TestDetectProfile(t *testing.T) {
+	is := is.New(t)
+
+	truecolor := environ{"TERM": "xterm-256color", "COLORTERM": "truecolor"}
+	var notTTY bytes.Buffer
+
+	for _, tc := range []struct {
+		mode    string
+		environ environ
+		want    termenv.Profile
+	}{
+		{ColorNever, truecolor, termenv.Ascii},
+		// A buffer is not a terminal
+		{ColorAuto, truecolor, termenv.Ascii},
+		{ColorAlways, truecolor, termenv.TrueColor},
+		{ColorAlways, environ{"TERM": "xterm-256color"}, termenv.ANSI256},
+		{ColorAlways, environ{"TERM": "linux"}, termenv.ANSI},
+		{ColorAlways, environ{"TERM": "vt220"}, termenv.ANSI},
+		{ColorAlways, environ{"TERM": "xterm-256color", "NO_COLOR": "1"}, termenv.ANSI256},
+	} {
+		profile, err := DetectProfile(tc.mode, &notTTY, tc.environ)
+		is.NoErr(err)
+		is.Equal(profile, tc.want) // mode and environment from the table
+	}
+
+	_, err := DetectProfile("sometimes", &notTTY, truecolor)
+	is.True(err != nil)
+}
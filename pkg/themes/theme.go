@@ -0,0 +1,283 @@
+package themes
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Ardelean-Calin/elmo/pkg/config"
+	"github.com/BurntSushi/toml"
+	"github.com/charmbracelet/lipgloss"
+)
+
+//go:embed default.toml
+var defaultTheme string
+
+// Scope identifies a highlight scope, like "keyword.control". Scopes are
+// small so the highlighting of a file can store one per byte.
+type Scope uint8
+
+// Text is the scope of everything without a highlight
+const Text Scope = 0
+
+// scopes interns scope names. Grows as queries get loaded.
+var scopes = struct {
+	sync.Mutex
+	names []string
+	ids   map[string]Scope
+}{
+	names: []string{""},
+	ids:   map[string]Scope{"": Text},
+}
+
+// ScopeOf returns the scope with the given name. Once all 256 scopes are in
+// use, new names are mapped to their closest known parent.
+func ScopeOf(name string) Scope {
+	scopes.Lock()
+	defer scopes.Unlock()
+	for {
+		if id, ok := scopes.ids[name]; ok {
+			return id
+		}
+		if len(scopes.names) < 256 {
+			id := Scope(len(scopes.names))
+			scopes.names = append(scopes.names, name)
+			scopes.ids[name] = id
+			return id
+		}
+		name = parent(name)
+	}
+}
+
+// Name returns the name of the scope
+func (s Scope) Name() string {
+	scopes.Lock()
+	defer scopes.Unlock()
+	return scopes.names[s]
+}
+
+// parent returns the parent of a dotted scope, "" for top level scopes
+func parent(name string) string {
+	i := strings.LastIndexByte(name, '.')
+	if i < 0 {
+		return ""
+	}
+	return name[:i]
+}
+
+// Style is the look of a scope. Empty colors are inherited.
+type Style struct {
+	Fg        string `toml:"fg"`
+	Bg        string `toml:"bg"`
+	Bold      bool   `toml:"bold"`
+	Italic    bool   `toml:"italic"`
+	Underline bool   `toml:"underline"`
+	Reverse   bool   `toml:"reverse"`
+}
+
+// Inherit fills the unset colors of s from parent and adds its modifiers
+func (s Style) Inherit(parent Style) Style {
+	if s.Fg == "" {
+		s.Fg = parent.Fg
+	}
+	if s.Bg == "" {
+		s.Bg = parent.Bg
+	}
+	s.Bold = s.Bold || parent.Bold
+	s.Italic = s.Italic || parent.Italic
+	s.Underline = s.Underline || parent.Underline
+	s.Reverse = s.Reverse || parent.Reverse
+	return s
+}
+
+// Lipgloss converts the style into a lipgloss style
+func (s Style) Lipgloss() lipgloss.Style {
+	style := lipgloss.NewStyle().
+		Bold(s.Bold).
+		Italic(s.Italic).
+		Underline(s.Underline).
+		Reverse(s.Reverse)
+	if s.Fg != "" {
+		style = style.Foreground(lipgloss.Color(s.Fg))
+	}
+	if s.Bg != "" {
+		style = style.Background(lipgloss.Color(s.Bg))
+	}
+	return style
+}
+
+// Theme maps highlight scopes onto styles
+type Theme struct {
+	palette map[string]string
+	styles  map[string]Style
+	cache   map[Scope]Style // Resolved styles, reset on every change
+	version int             // Incremented on every change
+}
+
+// Default returns the built-in theme
+func Default() *Theme {
+	t := &Theme{palette: map[string]string{}, styles: map[string]Style{}}
+	if err := t.parse(defaultTheme); err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Load returns the default theme overridden by the user's theme.toml. The
+// default theme is returned even if the user's file is broken.
+func Load() (*Theme, error) {
+	t := Default()
+	content, err := os.ReadFile(filepath.Join(config.Dir(), "theme.toml"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return t, err
+	}
+	user := Default()
+	if err := user.parse(string(content)); err != nil {
+		return t, fmt.Errorf("theme.toml: %w", err)
+	}
+	return user, nil
+}
+
+// parse reads the styles and palette of a theme file, replacing the ones
+// already defined
+func (t *Theme) parse(content string) error {
+	var keys map[string]toml.Primitive
+	md, err := toml.Decode(content, &keys)
+	if err != nil {
+		return err
+	}
+	for key, value := range keys {
+		if key == "palette" {
+			err = md.PrimitiveDecode(value, &t.palette)
+		} else {
+			err = t.parseStyle(md, key, value)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	t.cache = nil
+	t.version++
+	return nil
+}
+
+// parseStyle reads the style of a scope. Unquoted dotted keys turn into
+// nested tables, so other keys inside the table are the styles of child
+// scopes.
+func (t *Theme) parseStyle(md toml.MetaData, scope string, value toml.Primitive) error {
+	var style Style
+	if md.PrimitiveDecode(value, &style.Fg) == nil {
+		t.styles[scope] = style
+		return nil
+	}
+
+	var table map[string]toml.Primitive
+	if err := md.PrimitiveDecode(value, &table); err != nil {
+		return err
+	}
+	isStyle := false
+	for key, value := range table {
+		var err error
+		switch key {
+		case "fg":
+			err = md.PrimitiveDecode(value, &style.Fg)
+		case "bg":
+			err = md.PrimitiveDecode(value, &style.Bg)
+		case "bold":
+			err = md.PrimitiveDecode(value, &style.Bold)
+		case "italic":
+			err = md.PrimitiveDecode(value, &style.Italic)
+		case "underline":
+			err = md.PrimitiveDecode(value, &style.Underline)
+		case "reverse":
+			err = md.PrimitiveDecode(value, &style.Reverse)
+		default:
+			if err := t.parseStyle(md, scope+"."+key, value); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		isStyle = true
+	}
+	if isStyle {
+		t.styles[scope] = style
+	}
+	return nil
+}
+
+// Palette returns a copy of the palette
+func (t *Theme) Palette() map[string]string {
+	return maps.Clone(t.palette)
+}
+
+// SetPalette replaces the colors of the palette with the given ones. Used to
+// apply base16 themes.
+func (t *Theme) SetPalette(palette map[string]string) {
+	maps.Copy(t.palette, palette)
+	t.cache = nil
+	t.version++
+}
+
+// Version changes every time the theme does, so anything rendered with an
+// older version has to be rendered again
+func (t *Theme) Version() int {
+	return t.version
+}
+
+// color resolves palette names
+func (t *Theme) color(c string) string {
+	if value, ok := t.palette[c]; ok {
+		return value
+	}
+	return c
+}
+
+// Get returns the style of the named scope, or of its closest parent, on
+// top of "ui.text"
+func (t *Theme) Get(name string) Style {
+	style := t.lookup(name)
+	if name == "ui.text" {
+		return style
+	}
+	return style.Inherit(t.lookup("ui.text"))
+}
+
+// lookup returns the style of the scope or of its closest parent
+func (t *Theme) lookup(name string) Style {
+	for {
+		if style, ok := t.styles[name]; ok {
+			style.Fg, style.Bg = t.color(style.Fg), t.color(style.Bg)
+			return style
+		}
+		if name == "" {
+			return Style{}
+		}
+		name = parent(name)
+	}
+}
+
+// Style returns the style of the scope, like Get. The result is cached, as
+// it is called for every character on screen.
+func (t *Theme) Style(s Scope) Style {
+	if style, ok := t.cache[s]; ok {
+		return style
+	}
+	if t.cache == nil {
+		t.cache = map[Scope]Style{}
+	}
+	style := t.Get(s.Name())
+	t.cache[s] = style
+	return style
+}
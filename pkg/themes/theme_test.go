@@ -0,0 +1,65 @@
+package themes
+
+import (
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestFallback(t *testing.T) {
+	is := is.New(t)
+
+	theme := Default()
+	text := theme.Get("ui.text")
+	is.Equal(text, Style{Fg: "#cdd6f4", Bg: "#1e1e2e"})
+
+	// Parent scopes are used until one has a style
+	is.Equal(theme.Get("keyword.control.return"), Style{Fg: "#cba6f7", Bg: text.Bg})
+	is.Equal(theme.Get("string.special.path"), theme.Get("string"))
+	// Unknown scopes look like text
+	is.Equal(theme.Get("nonexistent"), text)
+	is.Equal(theme.Style(Text), text)
+	is.Equal(theme.Style(ScopeOf("function.method")), theme.Get("function"))
+}
+
+func TestParse(t *testing.T) {
+	is := is.New(t)
+
+	theme := Default()
+	is.NoErr(theme.parse(`
+"keyword.control" = { fg = "red", bold = true }
+"ui.selection" = { bg = "#000000", underline = true }
+comment = { italic = true }
+
+# Unquoted dotted keys are nested tables
+[function]
+fg = "#111111"
+method = { fg = "8" }
+
+[palette]
+red = "#ff0000"
+base00 = "#222222"
+`))
+
+	text := theme.Get("ui.text")
+	is.Equal(text.Bg, "#222222") // palette colors can be replaced
+	is.Equal(theme.Get("keyword.control.conditional"), Style{Fg: "#ff0000", Bg: "#222222", Bold: true})
+	is.Equal(theme.Get("keyword"), Style{Fg: "#cba6f7", Bg: "#222222"}) // untouched styles are kept
+	is.Equal(theme.Get("ui.selection"), Style{Fg: text.Fg, Bg: "#000000", Underline: true})
+	is.Equal(theme.Get("comment"), Style{Fg: text.Fg, Bg: text.Bg, Italic: true})
+	is.Equal(theme.Get("function").Fg, "#111111")
+	is.Equal(theme.Get("function.method").Fg, "8")
+	is.Equal(theme.Get("function.builtin").Fg, "#111111")
+
+	is.True(theme.parse(`keyword = { fg = 1 }`) != nil)
+}
+
+func TestScopes(t *testing.T) {
+	is := is.New(t)
+
+	a := ScopeOf("test.scope")
+	is.Equal(ScopeOf("test.scope"), a)
+	is.Equal(a.Name(), "test.scope")
+	is.True(ScopeOf("test.other") != a)
+	is.Equal(ScopeOf(""), Text)
+}
@@ -1,8 +1,24 @@
 package themes
 
-import "github.com/charmbracelet/lipgloss"
+import (
+	_ "embed"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+//go:embed themes.json
+var base16JSON []byte
 
 type Base16Theme struct {
+	Name   string // Name used by the :theme command
+	Scheme string // Full name of the scheme
+	Author string
+
 	Base00 lipgloss.Color // Default Background
 	Base01 lipgloss.Color // Lighter Background (Used for status bars, line number and folding marks)
 	Base02 lipgloss.Color // Selection Background
@@ -42,3 +58,73 @@ func DefaultTheme() Base16Theme {
 		Base0F: lipgloss.Color("#d65d0e"),
 	}
 }
+
+// Palette returns the colors of the theme as palette entries for a Theme
+func (b Base16Theme) Palette() map[string]string {
+	colors := []lipgloss.Color{
+		b.Base00, b.Base01, b.Base02, b.Base03, b.Base04, b.Base05, b.Base06, b.Base07,
+		b.Base08, b.Base09, b.Base0A, b.Base0B, b.Base0C, b.Base0D, b.Base0E, b.Base0F,
+	}
+	palette := make(map[string]string, len(colors))
+	for i, c := range colors {
+		palette["base0"+strings.ToUpper(strconv.FormatInt(int64(i), 16))] = string(c)
+	}
+	return palette
+}
+
+var base16 struct {
+	once   sync.Once
+	themes []Base16Theme
+}
+
+// Base16Themes returns the themes inside themes.json, sorted by name
+func Base16Themes() []Base16Theme {
+	base16.once.Do(func() {
+		var file struct {
+			Themes map[string]map[string]string `json:"themes"`
+		}
+		if err := json.Unmarshal(base16JSON, &file); err != nil {
+			panic(err)
+		}
+		for name, scheme := range file.Themes {
+			color := func(key string) lipgloss.Color {
+				return lipgloss.Color("#" + scheme[key])
+			}
+			base16.themes = append(base16.themes, Base16Theme{
+				Name:   name,
+				Scheme: scheme["scheme"],
+				Author: scheme["author"],
+				Base00: color("base00"),
+				Base01: color("base01"),
+				Base02: color("base02"),
+				Base03: color("base03"),
+				Base04: color("base04"),
+				Base05: color("base05"),
+				Base06: color("base06"),
+				Base07: color("base07"),
+				Base08: color("base08"),
+				Base09: color("base09"),
+				Base0A: color("base0A"),
+				Base0B: color("base0B"),
+				Base0C: color("base0C"),
+				Base0D: color("base0D"),
+				Base0E: color("base0E"),
+				Base0F: color("base0F"),
+			})
+		}
+		sort.Slice(base16.themes, func(i, j int) bool {
+			return base16.themes[i].Name < base16.themes[j].Name
+		})
+	})
+	return base16.themes
+}
+
+// FindBase16 returns the base16 theme with the given name
+func FindBase16(name string) (Base16Theme, bool) {
+	themes := Base16Themes()
+	i := sort.Search(len(themes), func(i int) bool { return themes[i].Name >= name })
+	if i < len(themes) && themes[i].Name == name {
+		return themes[i], true
+	}
+	return Base16Theme{}, false
+}
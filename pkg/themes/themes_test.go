@@ -0,0 +1,42 @@
+package themes
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/matryer/is"
+)
+
+func TestBase16Themes(t *testing.T) {
+	is := is.New(t)
+
+	all := Base16Themes()
+	is.True(len(all) > 200)
+	is.True(sort.SliceIsSorted(all, func(i, j int) bool { return all[i].Name < all[j].Name }))
+
+	theme, ok := FindBase16("3024")
+	is.True(ok)
+	is.Equal(theme.Scheme, "3024")
+	is.Equal(theme.Base00, lipgloss.Color("#090300"))
+	is.Equal(theme.Base0A, lipgloss.Color("#fded02"))
+
+	_, ok = FindBase16("nonexistent")
+	is.True(!ok)
+}
+
+func TestBase16Palette(t *testing.T) {
+	is := is.New(t)
+
+	base16, _ := FindBase16("3024")
+	theme := Default()
+	original := theme.Palette()
+	is.True(theme.Get("keyword").Fg != "#a16a94")
+
+	theme.SetPalette(base16.Palette())
+	is.Equal(theme.Get("keyword"), Style{Fg: "#a16a94", Bg: "#090300"}) // base0E on base00
+	is.Equal(theme.Style(ScopeOf("keyword")).Fg, "#a16a94")             // the cache got reset
+
+	theme.SetPalette(original)
+	is.Equal(theme.Get("keyword"), Default().Get("keyword"))
+}
@@ -1,31 +1,44 @@
 package footer
 
 import (
+	"strings"
 	"unicode/utf8"
 
+	"github.com/Ardelean-Calin/elmo/pkg/themes"
+
 	"github.com/charmbracelet/bubbles/cursor"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 )
 
+// Completer returns the candidates for the last word of a command. words
+// always has at least one element, the last one being empty when the cursor
+// is after a space.
+type Completer func(words []string) []string
+
 type Model struct {
 	text          string // The command input is a simple line of text
 	focused       bool
 	error, status string
-	errorStyle    lipgloss.Style
+	theme         *themes.Theme
 	cursor        cursor.Model
+	// Tab completion. Pressing tab again cycles through the candidates
+	completer   Completer
+	completions []string
+	completion  int    // Index of the current candidate
+	prefix      string // Text before the completed word
 }
 
-func New() Model {
+func New(theme *themes.Theme) Model {
 	cursor := cursor.New()
 	cursor.Focus()
 	return Model{
-		text:       "",
-		focused:    false,
-		error:      "",
-		status:     "",
-		errorStyle: lipgloss.NewStyle().Foreground(lipgloss.Color("#e78284")),
-		cursor:     cursor,
+		text:    "",
+		focused: false,
+		error:   "",
+		status:  "",
+		theme:   theme,
+		cursor:  cursor,
 	}
 }
 
@@ -45,6 +58,35 @@ func (m *Model) ShowError(err string) {
 	m.error = err
 }
 
+// SetCompleter sets the function used for tab completion
+func (m *Model) SetCompleter(completer Completer) {
+	m.completer = completer
+}
+
+// complete replaces the last word with the next (or previous) candidate
+func (m *Model) complete(step int) {
+	if m.completer == nil {
+		return
+	}
+	if m.completions == nil {
+		words := strings.Fields(m.text)
+		if len(words) == 0 || strings.HasSuffix(m.text, " ") {
+			words = append(words, "")
+		}
+		m.completions = m.completer(words)
+		if len(m.completions) == 0 {
+			m.completions = nil
+			return
+		}
+		last := words[len(words)-1]
+		m.prefix = m.text[:len(m.text)-len(last)]
+		m.completion = 0
+	} else {
+		m.completion = (m.completion + step + len(m.completions)) % len(m.completions)
+	}
+	m.text = m.prefix + m.completions[m.completion]
+}
+
 func (m *Model) Clear() {
 	m.error = ""
 	m.status = ""
@@ -60,7 +102,20 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		// Any other key accepts the current completion
+		if msg.Type != tea.KeyTab && msg.Type != tea.KeyShiftTab {
+			m.completions = nil
+		}
+
 		switch msg.Type {
+		case tea.KeyTab:
+			if m.focused {
+				m.complete(1)
+			}
+		case tea.KeyShiftTab:
+			if m.focused {
+				m.complete(-1)
+			}
 		case tea.KeyEnter:
 			if !m.focused {
 				return m, nil
@@ -96,7 +151,8 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 func (m Model) View() string {
 	var s string = ""
 	if m.error != "" {
-		s += m.errorStyle.Render(m.error)
+		errorStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Get("error").Fg))
+		s += errorStyle.Render(m.error)
 	} else if m.status != "" {
 		s += m.status
 	} else if m.focused {
@@ -0,0 +1,178 @@
+package picker
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/Ardelean-Calin/elmo/pkg/themes"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ChangedMsg is sent when another item gets highlighted, so it can be
+// previewed
+type ChangedMsg string
+
+// SelectedMsg is sent when an item is picked with enter
+type SelectedMsg string
+
+// CanceledMsg is sent when the picker is closed without picking anything
+type CanceledMsg struct{}
+
+// Model is a list of items which can be filtered by typing
+type Model struct {
+	Width   int
+	Height  int // Maximum number of items shown at once
+	opened  bool
+	title   string
+	items   []string
+	filter  string
+	matches []string // Items containing the filter
+	cursor  int      // Index of the highlighted match
+	offset  int      // Index of the first visible match
+	theme   *themes.Theme
+}
+
+func New(theme *themes.Theme) Model {
+	return Model{
+		Height: 10,
+		theme:  theme,
+	}
+}
+
+// Open shows the picker with the given item highlighted
+func (m *Model) Open(title string, items []string, selected string) {
+	m.opened = true
+	m.title = title
+	m.items = items
+	m.filter = ""
+	m.matches = items
+	m.cursor = max(0, slices.Index(items, selected))
+	m.offset = max(0, m.cursor-m.Height/2)
+}
+
+// Opened reports whether the picker is visible
+func (m Model) Opened() bool {
+	return m.opened
+}
+
+func (m Model) Init() tea.Cmd {
+	return nil
+}
+
+// Update handles the keys while the picker is opened
+func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
+	key, ok := msg.(tea.KeyMsg)
+	if !m.opened || !ok {
+		return m, nil
+	}
+
+	previous := m.current()
+	switch key.String() {
+	case "esc", "ctrl+c":
+		m.opened = false
+		return m, event(CanceledMsg{})
+	case "enter":
+		m.opened = false
+		if selected := m.current(); selected != "" {
+			return m, event(SelectedMsg(selected))
+		}
+		return m, event(CanceledMsg{})
+	case "up", "ctrl+p", "shift+tab":
+		m.move(-1)
+	case "down", "ctrl+n", "tab":
+		m.move(1)
+	case "pgup":
+		m.move(-m.Height)
+	case "pgdown":
+		m.move(m.Height)
+	case "backspace":
+		if len(m.filter) > 0 {
+			runes := []rune(m.filter)
+			m.setFilter(string(runes[:len(runes)-1]))
+		}
+	case " ":
+		m.setFilter(m.filter + " ")
+	default:
+		if key.Type == tea.KeyRunes {
+			m.setFilter(m.filter + string(key.Runes))
+		}
+	}
+
+	if current := m.current(); current != previous && current != "" {
+		return m, event(ChangedMsg(current))
+	}
+	return m, nil
+}
+
+// current returns the highlighted item, or "" if nothing matches
+func (m Model) current() string {
+	if m.cursor >= len(m.matches) {
+		return ""
+	}
+	return m.matches[m.cursor]
+}
+
+// move moves the highlight by n items, wrapping around at the ends
+func (m *Model) move(n int) {
+	if len(m.matches) == 0 {
+		return
+	}
+	if n == 1 || n == -1 {
+		m.cursor = (m.cursor + n + len(m.matches)) % len(m.matches)
+	} else {
+		m.cursor = max(0, min(m.cursor+n, len(m.matches)-1))
+	}
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	}
+	if m.cursor >= m.offset+m.Height {
+		m.offset = m.cursor - m.Height + 1
+	}
+}
+
+// setFilter keeps only the items containing filter, ignoring case
+func (m *Model) setFilter(filter string) {
+	m.filter = filter
+	m.matches = nil
+	needle := strings.ToLower(filter)
+	for _, item := range m.items {
+		if strings.Contains(strings.ToLower(item), needle) {
+			m.matches = append(m.matches, item)
+		}
+	}
+	m.cursor = 0
+	m.offset = 0
+}
+
+// View renders the visible items, followed by the prompt
+func (m Model) View() string {
+	if !m.opened {
+		return ""
+	}
+	// Every item takes exactly one line
+	text := m.theme.Get("ui.popup").Lipgloss().Width(m.Width).MaxHeight(1)
+	selected := m.theme.Get("ui.popup.selected")
+	selected.Reverse = selected.Reverse || themes.NoColor()
+	selectedStyle := selected.Lipgloss().Width(m.Width).MaxHeight(1)
+
+	var lines []string
+	end := min(m.offset+m.Height, len(m.matches))
+	for i := m.offset; i < end; i++ {
+		item := " " + m.matches[i]
+		if i == m.cursor {
+			lines = append(lines, selectedStyle.Render(item))
+		} else {
+			lines = append(lines, text.Render(item))
+		}
+	}
+	lines = append(lines, text.Render(m.title+": "+m.filter+"█"))
+	return strings.Join(lines, "\n")
+}
+
+// event returns a message as a command
+func event(msg tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		return msg
+	}
+}
@@ -18,6 +18,7 @@ const (
 type Model struct {
 	mode       Mode
 	bufferPath string
+	largeFile  bool
 	Width      int
 }
 
@@ -44,6 +45,11 @@ func (m *Model) SetOpenBuffer(path string) {
 	m.bufferPath = path
 }
 
+// SetLargeFile shows or hides the large file mode indicator
+func (m *Model) SetLargeFile(large bool) {
+	m.largeFile = large
+}
+
 func (m Model) Init() tea.Cmd {
 	// Just return `nil`, which means "no I/O right now, please."
 	return nil
@@ -57,9 +63,13 @@ func (m Model) View() string {
 	modeString := lipgloss.NewStyle().
 		Padding(0, 1).
 		Render(string(m.mode))
+	info := "LF  go"
+	if m.largeFile {
+		info = "LARGE FILE  " + info
+	}
 	infoString := lipgloss.NewStyle().
 		Padding(0, 1).
-		Render("LF  go")
+		Render(info)
 
 	// Center the buffer string.
 	bufferString := lipgloss.PlaceHorizontal(m.Width, lipgloss.Center, m.bufferPath)
@@ -2,6 +2,9 @@ package textarea
 
 import (
 	"github.com/Ardelean-Calin/elmo/pkg/buffer"
+	"github.com/Ardelean-Calin/elmo/pkg/buffer/syntax"
+	"github.com/Ardelean-Calin/elmo/pkg/config"
+	"github.com/Ardelean-Calin/elmo/pkg/themes"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -26,9 +29,9 @@ type Model struct {
 	Buffer        buffer.Model // Currently displayed buffer
 }
 
-func New() Model {
+func New(cfg config.Config, langs *syntax.Registry, theme *themes.Theme) Model {
 	return Model{
-		Buffer:  buffer.New(),
+		Buffer:  buffer.New(cfg, langs, theme),
 		Focused: false,
 	}
 }